@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// netList is a repeatable flag of client networks, CIDRs or IP addresses, see -allownet and -denynet.
+type netList []*net.IPNet
+
+// netFlag defines a repeatable network list flag.
+func netFlag(name, usage string) *netList {
+	nl := new(netList)
+	flag.Var(nl, name, usage)
+	return nl
+}
+
+func (nl *netList) String() string {
+	if nl == nil {
+		return ""
+	}
+	var s []string
+	for _, n := range *nl {
+		s = append(s, n.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+func (nl *netList) Set(s string) error {
+	nets, err := parseCIDRs(s)
+	if err != nil {
+		return err
+	}
+	*nl = append(*nl, nets...)
+	return nil
+}
+
+// contains reports whether ip is in any of the networks.
+func (nl netList) contains(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range nl {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// netAllowed reports whether the client at ip may connect: it's not in -denynet,
+// and it's in -allownet if that's set.
+func netAllowed(ip string) bool {
+	if len(*allowNets) > 0 && !allowNets.contains(ip) {
+		return false
+	}
+	return !denyNets.contains(ip)
+}
+
+// requireNet serves h only to clients allowed by -allownet and -denynet, others get 403.
+// Clients are identified by their real IP, see -trustproxy.
+func requireNet(h http.Handler) http.Handler {
+	if len(*allowNets) == 0 && len(*denyNets) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := clientIP(r); !netAllowed(ip) {
+			if *verbose {
+				fmt.Fprintf(infoOut, "Rejected client %v, see -allownet and -denynet, at %v\n", ip, time.Now().Format(time.Stamp))
+			}
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
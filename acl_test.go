@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNetListContains(t *testing.T) {
+	var nl netList
+	for _, s := range []string{"192.168.1.0/24", "10.0.0.7", "fd00::/8", "2001:db8::1"} {
+		if err := nl.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		ip   string
+		want bool
+	}{
+		{"192.168.1.1", true},
+		{"192.168.1.255", true},
+		{"192.168.2.1", false},
+		{"10.0.0.7", true},
+		{"10.0.0.8", false},
+		{"::ffff:192.168.1.20", true}, // v4-mapped
+		{"::ffff:10.0.0.7", true},
+		{"::ffff:192.168.2.1", false},
+		{"fd12:3456::1", true},
+		{"fe80::1", false},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+		{"::1", false},
+		{"", false},
+		{"not an address", false},
+	} {
+		if got := nl.contains(tc.ip); got != tc.want {
+			t.Errorf("contains(%#v) = %v, want %v", tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestNetListSet(t *testing.T) {
+	for _, tc := range []struct {
+		s   string
+		err bool
+	}{
+		{"192.168.1.0/24", false},
+		{"fd00::/8", false},
+		{"10.0.0.1, 2001:db8::/32", false},
+		{"192.168.1.0/33", true},
+		{"fd00::/129", true},
+		{"192.168.1", true},
+		{"example.com", true},
+	} {
+		var nl netList
+		if err := nl.Set(tc.s); (err != nil) != tc.err {
+			t.Errorf("Set(%#v) error %v, want error %v", tc.s, err, tc.err)
+		}
+	}
+}
+
+func TestRequireNet(t *testing.T) {
+	allow, deny, proxies := *allowNets, *denyNets, trustedProxies
+	defer func() { *allowNets, *denyNets, trustedProxies = allow, deny, proxies }()
+
+	*allowNets, *denyNets = nil, nil
+	for _, s := range []string{"192.168.1.0/24", "fd00::/8"} {
+		if err := allowNets.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range []string{"192.168.1.66", "fd00:bad::/32"} {
+		if err := denyNets.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, proxy, _ := net.ParseCIDR("127.0.0.1/32")
+	trustedProxies = []*net.IPNet{proxy}
+
+	h := requireNet(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range []struct {
+		remoteAddr, forwardedFor string
+		code                     int
+	}{
+		{"192.168.1.10:5000", "", http.StatusOK},
+		{"[::ffff:192.168.1.10]:5000", "", http.StatusOK},
+		{"[fd00::10]:5000", "", http.StatusOK},
+		{"192.168.1.66:5000", "", http.StatusForbidden}, // denied, takes precedence
+		{"[fd00:bad::10]:5000", "", http.StatusForbidden},
+		{"192.168.2.10:5000", "", http.StatusForbidden}, // not allowed
+		{"[2001:db8::10]:5000", "", http.StatusForbidden},
+		{"127.0.0.1:5000", "192.168.1.10", http.StatusOK}, // real IP behind trusted proxy
+		{"127.0.0.1:5000", "fd00:bad::1", http.StatusForbidden},
+		{"127.0.0.1:5000", "", http.StatusForbidden},
+		{"192.168.2.10:5000", "192.168.1.10", http.StatusForbidden}, // untrusted proxy
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%v (X-Forwarded-For %#v): got %v, want %v", tc.remoteAddr, tc.forwardedFor, w.Code, tc.code)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// requireAdmin serves h only if -admin is set and the client authenticates.
+func requireAdmin(h http.Handler) http.Handler {
+	if *adminAuth == "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "admin endpoints disabled, see -admin", http.StatusForbidden)
+		})
+	}
+	return requireAuth(h)
+}
+
+// absPath returns p as an absolute path. Relative paths are relative to root.
+func absPath(root, p string) string {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+	return filepath.Clean(p)
+}
+
+// blacklistHandler adds (POST) or removes (DELETE) the file given in form value path
+// to the blacklist, GET lists blacklisted files.
+// POST with skip=1 also skips the file if it's being broadcast.
+type blacklistHandler struct {
+	*mux
+}
+
+func (bh blacklistHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.Method == http.MethodGet {
+		fmt.Fprint(w, strings.Join(append(bh.blacklist.list(), ""), "\n"))
+		return
+	}
+
+	p := r.FormValue("path")
+	if p == "" {
+		http.Error(w, "missing path", http.StatusBadRequest)
+		return
+	}
+	p = absPath(bh.path, p)
+
+	switch r.Method {
+	case http.MethodPost:
+		if !bh.blacklist.add(p) {
+			fmt.Fprintf(w, "already blacklisted: %v\n", p)
+			return
+		}
+		fmt.Fprintf(w, "blacklisted: %v\n", p)
+		if r.FormValue("skip") != "" && bh.skipIfPlaying(p) {
+			fmt.Fprintf(w, "skipped: %v\n", p)
+		}
+	case http.MethodDelete:
+		if !bh.blacklist.remove(p) {
+			http.Error(w, "not blacklisted: "+p, http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "removed from blacklist: %v\n", p)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// connection describes a connected client on /connections.
+type connection struct {
+	QID        int       `json:"qid"`
+	RemoteAddr string    `json:"remoteAddr"`
+	UserAgent  string    `json:"userAgent"`
+	Started    time.Time `json:"started"`
+	BytesSent  int64     `json:"bytesSent"`
+	Dropped    int64     `json:"droppedFrames,omitempty"` // see -degrade
+	Frames     int64     `json:"frames,omitempty"`        // frames sent, see -debugframes
+	LastFrame  int64     `json:"lastFrame,omitempty"`     // sequence number of last frame sent, see -debugframes
+}
+
+// connectionsHandler lists connected clients as JSON, ordered by qid.
+type connectionsHandler struct {
+	*mux
+}
+
+func (ch connectionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ch.Lock()
+	conns := make([]connection, 0, len(ch.clients))
+	for qid, c := range ch.clients {
+		conns = append(conns, connection{
+			QID:        qid,
+			RemoteAddr: c.remoteAddr,
+			UserAgent:  c.userAgent,
+			Started:    c.started,
+			BytesSent:  atomic.LoadInt64(&c.bytesSent),
+			Dropped:    atomic.LoadInt64(&c.dropped),
+			Frames:     atomic.LoadInt64(&c.frames),
+			LastFrame:  atomic.LoadInt64(&c.lastFrame),
+		})
+	}
+	ch.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].QID < conns[j].QID })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(conns)
+}
+
+// disconnectHandler disconnects (POST) the client given in form value qid.
+type disconnectHandler struct {
+	*mux
+}
+
+func (dh disconnectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	qid, err := strconv.Atoi(r.FormValue("qid"))
+	if err != nil {
+		http.Error(w, "missing or invalid qid", http.StatusBadRequest)
+		return
+	}
+	if !dh.kick(qid) {
+		http.Error(w, fmt.Sprintf("no such connection: %v", qid), http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "disconnected: %v\n", qid)
+}
+
+// holdHandler sets (POST /stopafter) or clears (POST /resume) stopping after the current
+// track, silence is broadcast instead of the next track until resumed.
+type holdHandler struct {
+	*mux
+
+	stopAfter bool
+}
+
+func (hh holdHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hh.Lock()
+	hh.mux.stopAfter = hh.stopAfter
+	hh.Unlock()
+	if hh.stopAfter {
+		fmt.Fprint(w, "stopping after current track\n")
+	} else {
+		fmt.Fprint(w, "resumed\n")
+	}
+}
+
+// interruptHandler plays the file given in form value path (POST) immediately,
+// the interrupted track goes on after it.
+type interruptHandler struct {
+	*mux
+}
+
+func (ih interruptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := r.FormValue("path")
+	if p == "" {
+		http.Error(w, "missing path", http.StatusBadRequest)
+		return
+	}
+	requested := p
+	p = absPath(ih.path, p)
+	if rel, err := filepath.Rel(ih.path, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		http.Error(w, "not a playable file: "+requested, http.StatusBadRequest) // outside of path
+		return
+	}
+	if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() || !playable(p, info) {
+		http.Error(w, "not a playable file: "+requested, http.StatusBadRequest)
+		return
+	}
+	t, err := openTrack(p, nil)
+	if err != nil {
+		log.Printf("Opening %v for interrupt failed, err=%v", p, err)
+		http.Error(w, "can't play "+requested, http.StatusBadRequest)
+		return
+	}
+	t.source = fromInterrupt
+
+	select {
+	case ih.interrupt <- t:
+		fmt.Fprintf(w, "interrupting with: %v\n", p)
+	default:
+		t.c.Close()
+		http.Error(w, "an interrupt is already pending", http.StatusConflict)
+	}
+}
+
+// replayHandler plays the track played before the current one again (POST), after the
+// current track ends.
+type replayHandler struct {
+	*mux
+}
+
+func (rh replayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rh.Lock()
+	current := rh.playing.Path
+	rh.Unlock()
+	p := rh.history.last(current)
+	if p == "" {
+		http.Error(w, "nothing played yet", http.StatusNotFound)
+		return
+	}
+	t, err := openTrack(p, func(info os.FileInfo) bool {
+		return info.Mode().IsRegular() && playable(p, info) && !rh.blacklist.has(p)
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't replay %v: %v", p, err), http.StatusGone)
+		return
+	}
+	t.source = fromReplay
+
+	select {
+	case rh.replay <- t:
+		fmt.Fprintf(w, "replaying next: %v\n", p)
+	default:
+		t.c.Close()
+		http.Error(w, "a replay is already pending", http.StatusConflict)
+	}
+}
+
+// configHandler serves the effective configuration as JSON (GET): every flag's value,
+// set or default, and the path broadcast. Secrets are redacted.
+type configHandler struct {
+	*mux
+}
+
+func (ch configHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg := map[string]string{"path": ch.path}
+	flag.VisitAll(func(f *flag.Flag) {
+		cfg[f.Name] = redact(f.Name, f.Value.String())
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(cfg)
+}
+
+// redact hides the secrets in the value v of flag name: the -admin password and the -webhook
+// URL, webhooks often carry their token in the path. Only scheme and host of other URLs are
+// shown, their user info, path or query may be secret too.
+func redact(name, v string) string {
+	if v == "" {
+		return v
+	}
+	switch name {
+	case "admin":
+		if i := strings.Index(v, ":"); i >= 0 {
+			return v[:i+1] + "xxxxx"
+		}
+		return "xxxxx"
+	case "webhook":
+		return "xxxxx"
+	}
+	if u, err := url.Parse(v); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return v
+}
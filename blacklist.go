@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// blacklist is the set of files never broadcast. If path is set, the set is persisted.
+type blacklist struct {
+	sync.Mutex
+
+	files map[string]bool
+	path  string // blacklist file, empty: not persisted
+}
+
+// loadBlacklist reads the blacklist file at path, one file per line. A missing file results in an empty blacklist.
+func loadBlacklist(path string) *blacklist {
+	bl := &blacklist{files: make(map[string]bool), path: path}
+	if path == "" {
+		return bl
+	}
+
+	files, err := readLines(path)
+	if err != nil {
+		if debugging && !os.IsNotExist(err) {
+			log.Printf("Ignoring blacklist file %#v, err=%v", path, err)
+		}
+		return bl
+	}
+	for _, f := range files {
+		bl.files[f] = true
+	}
+
+	return bl
+}
+
+func (bl *blacklist) has(file string) bool {
+	bl.Lock()
+	defer bl.Unlock()
+	return bl.files[file]
+}
+
+// add adds file to blacklist, returns false if it was already blacklisted.
+func (bl *blacklist) add(file string) bool {
+	bl.Lock()
+	defer bl.Unlock()
+	if bl.files[file] {
+		return false
+	}
+	bl.files[file] = true
+	bl.save()
+	return true
+}
+
+// remove removes file from blacklist, returns false if it wasn't blacklisted.
+func (bl *blacklist) remove(file string) bool {
+	bl.Lock()
+	defer bl.Unlock()
+	if !bl.files[file] {
+		return false
+	}
+	delete(bl.files, file)
+	bl.save()
+	return true
+}
+
+// list returns blacklisted files in sorted order.
+func (bl *blacklist) list() []string {
+	bl.Lock()
+	defer bl.Unlock()
+	l := make([]string, 0, len(bl.files))
+	for f := range bl.files {
+		l = append(l, f)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// save writes blacklist file, must be called with bl locked.
+func (bl *blacklist) save() {
+	if bl.path == "" {
+		return
+	}
+	files := make([]string, 0, len(bl.files))
+	for f := range bl.files {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	if err := writeLines(bl.path, files); err != nil && debugging {
+		log.Printf("Saving blacklist file %#v failed, err=%v", bl.path, err)
+	}
+}
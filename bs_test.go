@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fgergo/mp3"
+)
+
+// testFrame returns a silent mp3 frame starting with header h, as long as h tells.
+func testFrame(h ...byte) []byte {
+	buf := make([]byte, 2048)
+	copy(buf, h)
+	var f mp3.Frame
+	skipped := 0
+	if err := mp3.NewDecoder(bytes.NewReader(buf)).Decode(&f, &skipped); err != nil {
+		panic(err)
+	}
+	b, _ := ioutil.ReadAll(f.Reader())
+	return b
+}
+
+var frame44k = testFrame(0xff, 0xfb, 0x90, 0x00) // MPEG1 Layer III, 128kbps, 44.1kHz, stereo
+
+// testStream returns a mux broadcasting frame over and over until it's stopped.
+func testStream(frame []byte) *mux {
+	m := new(mux)
+	m.init("mp3")
+	m.trackChanged = make(chan struct{})
+	frames := make(chan streamFrame)
+	go m.broadcast(frames)
+	go func() {
+		for {
+			select {
+			case frames <- frame:
+			case <-m.stopping:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// testMux returns a mux for decoding tracks with decode, without a broadcast.
+func testMux() *mux {
+	m := new(mux)
+	m.init("mp3")
+	m.skip = make(chan struct{}, 1)
+	m.interrupt = make(chan *track, 1)
+	m.lag, m.decoding, m.clock = new(lagMeter), new(decodeMeter), new(streamClock)
+	m.trackChanged = make(chan struct{})
+	return m
+}
+
+// decode returns the frames m.decodeMP3 sends decoding r, without pacing, and the number it returns.
+func decode(m *mux, r io.Reader, limit time.Duration, gapless bool) ([]streamFrame, int) {
+	frames := make(chan streamFrame)
+	p := &pacer{cumwait: -24 * time.Hour, lag: m.lag, decoding: m.decoding, rate: m.rate, clock: m.clock} // never sleeps
+	done := make(chan int)
+	go func() { done <- m.decodeMP3(r, frames, p, limit, gapless) }()
+	var sent []streamFrame
+	for {
+		select {
+		case f := <-frames:
+			sent = append(sent, f)
+		case n := <-done:
+			return sent, n
+		}
+	}
+}
+
+func TestStreamHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		record      bool
+		disposition string
+		start       []byte // sent before the first frame
+	}{
+		{false, "", []byte{'I', 'D', '3', 0x03, 0, 0, 0, 0, 0, 0}},
+		{true, "attachment; filename=stream.mp3", nil},
+	} {
+		m := testStream(frame44k)
+		srv := httptest.NewServer(streamHandler{mux: m, record: tc.record})
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("record %v: status %v", tc.record, resp.Status)
+		}
+		for h, want := range map[string]string{
+			"Content-Type":        "audio/mpeg",
+			"Cache-Control":       "no-cache",
+			"Content-Disposition": tc.disposition,
+			"Content-Length":      "",
+		} {
+			if got := resp.Header.Get(h); got != want {
+				t.Errorf("record %v: %v %#v, want %#v", tc.record, h, got, want)
+			}
+		}
+		if resp.ContentLength != -1 || len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+			t.Errorf("record %v: Content-Length %v, Transfer-Encoding %v, want chunked stream", tc.record, resp.ContentLength, resp.TransferEncoding)
+		}
+
+		want := append(append([]byte(nil), tc.start...), frame44k...)
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(resp.Body, got); err != nil {
+			t.Errorf("record %v: reading stream failed: %v", tc.record, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("record %v: stream starts with % x, want % x", tc.record, got[:16], want[:16])
+		}
+		resp.Body.Close()
+		m.stop()
+		srv.Close()
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		stream []byte
+		n      int
+	}{
+		{"empty", nil, 0},
+		{"header only", frame44k[:4], 0},
+		{"half a frame", frame44k[:len(frame44k)/2], 0},
+		{"not audio", bytes.Repeat([]byte("boring"), 100), 0},
+		{"truncated last frame", append(bytes.Repeat(frame44k, 10), frame44k[:100]...), 10},
+	} {
+		sent, n := decode(testMux(), bytes.NewReader(tc.stream), 0, false)
+		if n != tc.n || len(sent) != tc.n {
+			t.Errorf("%v: sent %v frames, decodeMP3 returned %v, want %v", tc.name, len(sent), n, tc.n)
+		}
+	}
+}
+
+func TestEmptyFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, b := range map[string][]byte{"empty.mp3": nil, "truncated.mp3": frame44k[:len(frame44k)/2]} {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := new(mux).start(dir)
+	defer m.stop()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		m.Lock()
+		n := m.emptyFiles
+		m.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v files without audio frames counted, want 2", n)
+		}
+	}
+}
+
+func TestLockFormat(t *testing.T) {
+	defer func(lock bool) { *lockFormat = lock }(*lockFormat)
+
+	frame48k := testFrame(0xff, 0xfb, 0x94, 0x00)     // MPEG1 Layer III, 128kbps, 48kHz, stereo
+	frame44kMono := testFrame(0xff, 0xfb, 0x90, 0xc0) // MPEG1 Layer III, 128kbps, 44.1kHz, mono
+	frame22k := testFrame(0xff, 0xf3, 0x90, 0x00)     // MPEG2 Layer III, 80kbps, 22.05kHz, stereo
+	files := [][]byte{frame44k, frame48k, frame44kMono, frame22k, frame44k}
+	for _, tc := range []struct {
+		lock bool
+		n    []int // frames played of files
+	}{
+		{false, []int{5, 5, 5, 5, 5}},
+		{true, []int{5, 0, 0, 0, 5}},
+	} {
+		*lockFormat = tc.lock
+		m := testMux()
+		for i, frame := range files {
+			_, n := decode(m, bytes.NewReader(bytes.Repeat(frame, 5)), 0, false)
+			if n != tc.n[i] {
+				t.Errorf("lockformat %v: %v frames of file %v played, want %v", tc.lock, n, i, tc.n[i])
+			}
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	fd := 1152 * time.Second / 44100 // duration of frame44k
+	file := bytes.Repeat(frame44k, 100)
+	m := testMux()
+	for _, limit := range []time.Duration{time.Second, 500 * time.Millisecond, fd, 2 * time.Second, time.Second} {
+		_, n := decode(m, bytes.NewReader(file), limit, false)
+		if d := time.Duration(n) * fd; d < limit-time.Millisecond || d >= limit+fd {
+			t.Errorf("preview %v: played %v frames, %v, want at least %v, less than a frame more", limit, n, d, limit)
+		}
+	}
+	for _, limit := range []time.Duration{0, time.Hour} {
+		if _, n := decode(m, bytes.NewReader(file), limit, false); n != 100 {
+			t.Errorf("preview %v: played %v frames, want all 100", limit, n)
+		}
+	}
+}
+
+// countingReader counts bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+func TestMaxErrorStreak(t *testing.T) {
+	defer func(n int) { *maxErrorStreak = n }(*maxErrorStreak)
+
+	garbage := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(garbage)
+	var glitchy []byte // 10 frames found after skipping bytes, then a clean one
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			glitchy = append(glitchy, "junk"...)
+			glitchy = append(glitchy, frame44k...)
+		}
+		glitchy = append(glitchy, frame44k...)
+	}
+	for _, tc := range []struct {
+		name   string
+		stream []byte
+		streak int
+		whole  bool // stream is read to its end
+		n      int  // frames played, -1: any
+	}{
+		{"garbage", garbage, 16, false, -1},
+		{"garbage", garbage, 0, true, -1},
+		{"glitchy", glitchy, 16, true, 110},
+		{"glitchy", glitchy, 10, false, 9},
+		{"clean", bytes.Repeat(frame44k, 100), 1, true, 100},
+	} {
+		*maxErrorStreak = tc.streak
+		r := &countingReader{r: bytes.NewReader(tc.stream)}
+		_, n := decode(testMux(), r, 0, false)
+		if whole := r.n == len(tc.stream); whole != tc.whole {
+			t.Errorf("%v, -maxerrorstreak %v: read %v of %v bytes", tc.name, tc.streak, r.n, len(tc.stream))
+		}
+		if tc.n >= 0 && n != tc.n {
+			t.Errorf("%v, -maxerrorstreak %v: played %v frames, want %v", tc.name, tc.streak, n, tc.n)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	for _, tc := range []struct {
+		kbps, minKbps int
+		n             int
+		wait          time.Duration
+	}{
+		{8, 0, 1000, 0},                      // a second's worth is sent at once
+		{8, 0, 1500, 500 * time.Millisecond}, // 500 bytes over, at 1000 bytes/s
+		{8, 80, 1500, 50 * time.Millisecond}, // raised to 10000 bytes/s
+		{80, 8, 1500, 0},
+	} {
+		tb := newThrottle(tc.kbps)
+		t0 := time.Now()
+		tb.wait(tc.n, tc.minKbps)
+		if d := time.Since(t0); d < tc.wait-10*time.Millisecond || d > tc.wait+200*time.Millisecond {
+			t.Errorf("%vkbps, at least %vkbps: sending %v bytes waited %v, want %v", tc.kbps, tc.minKbps, tc.n, d, tc.wait)
+		}
+	}
+}
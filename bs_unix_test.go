@@ -0,0 +1,88 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOpenTrackTimeout(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.mp3")
+	if err := os.WriteFile(good, bytes.Repeat(frame44k, 10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// opening a fifo blocks until there's a writer, reading it blocks until the writer writes
+	hungOpen, hungRead := filepath.Join(dir, "hung open.mp3"), filepath.Join(dir, "hung read.mp3")
+	for _, path := range []string{hungOpen, hungRead} {
+		if err := syscall.Mkfifo(path, 0644); err != nil {
+			t.Skip(err)
+		}
+	}
+	writers := make(chan *os.File, 2)
+	go func() {
+		w, err := os.OpenFile(hungRead, os.O_WRONLY, 0)
+		if err == nil {
+			writers <- w // never writes
+		}
+	}()
+	defer func() {
+		// end hung opens and reads left behind
+		if w, err := os.OpenFile(hungOpen, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			w.Close()
+		}
+		select {
+		case w := <-writers:
+			w.Close()
+		case <-time.After(time.Second):
+		}
+	}()
+
+	const d = 200 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	blockingCheck := func(os.FileInfo) bool { <-release; return false }
+	for _, tc := range []struct {
+		name    string
+		path    string
+		check   func(os.FileInfo) bool
+		timeout bool
+	}{
+		{"good", good, nil, false},
+		{"hung open", hungOpen, nil, true},
+		{"hung read", hungRead, nil, true},
+		{"hung stat", good, blockingCheck, true},
+		{"good after hung ones", good, nil, false},
+	} {
+		t0 := time.Now()
+		tr, err := openTrackTimeout(tc.path, tc.check, d)
+		took := time.Since(t0)
+		if took > d+time.Second {
+			t.Errorf("%v: opening took %v, timeout %v", tc.name, took, d)
+		}
+		if !tc.timeout {
+			if err != nil {
+				t.Errorf("%v: %v", tc.name, err)
+				continue
+			}
+			if _, n := decode(testMux(), tr.r, 0, false); n != 10 {
+				t.Errorf("%v: played %v frames, want 10", tc.name, n)
+			}
+			tr.c.Close()
+			continue
+		}
+		var pe *os.PathError
+		if !errors.Is(err, errOpenTimeout) || !errors.As(err, &pe) || pe.Path != tc.path {
+			t.Errorf("%v: error %v, want open %v timeout", tc.name, err, tc.path)
+		}
+		if took < d {
+			t.Errorf("%v: timed out after %v, want %v", tc.name, took, d)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// cacheVersion is incremented whenever fileRecord or cacheFile changes incompatibly,
+// cache files of other versions are discarded.
+const cacheVersion = 1
+
+// fileCache persists what was learned by reading files (probes, durations, loudness)
+// in -probecachefile, nil if not set.
+var fileCache *diskCache
+
+// diskCache keeps what was learned about files, keyed by path. A record is valid while
+// the file's modification time (and size, if known) are unchanged. It's saved gzipped
+// gob every 5 minutes if changed, and on shutdown. A file of another version or codec,
+// or one failing to decode, is discarded and rebuilt.
+type diskCache struct {
+	sync.Mutex
+
+	path  string
+	files map[string]*fileRecord
+	dirty bool // changed since saved
+}
+
+// fileRecord is what's known about a file.
+type fileRecord struct {
+	ModTime time.Time
+	Size    int64 // 0: unknown, only probed
+
+	Probed               bool
+	Artist, Title, Genre string
+	Kbps                 int
+
+	Measured bool // Duration is known, see /library
+	Duration time.Duration
+
+	Loudness *loudness // see -loudnessscan
+}
+
+// cacheFile is the content of -probecachefile.
+type cacheFile struct {
+	Version int
+	Codec   string // probes and durations depend on -codec
+	Files   map[string]*fileRecord
+}
+
+// loadDiskCache reads the cache file at path, a missing or invalid file gives an empty cache.
+func loadDiskCache(path string) *diskCache {
+	dc := &diskCache{path: path, files: make(map[string]*fileRecord)}
+	f, err := os.Open(path)
+	if err != nil {
+		if debugging && !os.IsNotExist(err) {
+			log.Printf("Ignoring probe cache file %#v, err=%v", path, err)
+		}
+		return dc
+	}
+	defer f.Close()
+	var cf cacheFile
+	zr, err := gzip.NewReader(f)
+	if err == nil {
+		err = gob.NewDecoder(zr).Decode(&cf)
+	}
+	switch {
+	case err != nil:
+		log.Printf("Error: probe cache file %#v is corrupt, rebuilding it: %v", path, err)
+	case cf.Version != cacheVersion || cf.Codec != *codec:
+		if debugging {
+			log.Printf("Discarding probe cache file %#v of version %v, codec %v", path, cf.Version, cf.Codec)
+		}
+	case cf.Files != nil:
+		dc.files = cf.Files
+	}
+	return dc
+}
+
+// record returns the record of path valid for modTime, a new one if there's none. Called with dc locked.
+func (dc *diskCache) record(path string, modTime time.Time) *fileRecord {
+	fr, ok := dc.files[path]
+	if !ok || !fr.ModTime.Equal(modTime) {
+		fr = &fileRecord{ModTime: modTime}
+		dc.files[path] = fr
+	}
+	dc.dirty = true
+	return fr
+}
+
+// probe returns the probe of path cached for modTime.
+func (dc *diskCache) probe(path string, modTime time.Time) (probe, bool) {
+	if dc == nil {
+		return probe{}, false
+	}
+	dc.Lock()
+	defer dc.Unlock()
+	fr, ok := dc.files[path]
+	if !ok || !fr.Probed || !fr.ModTime.Equal(modTime) {
+		return probe{}, false
+	}
+	return probe{artist: fr.Artist, title: fr.Title, genre: fr.Genre, kbps: fr.Kbps}, true
+}
+
+func (dc *diskCache) putProbe(path string, modTime time.Time, p probe) {
+	if dc == nil {
+		return
+	}
+	dc.Lock()
+	fr := dc.record(path, modTime)
+	fr.Probed = true
+	fr.Artist, fr.Title, fr.Genre, fr.Kbps = p.artist, p.title, p.genre, p.kbps
+	dc.Unlock()
+}
+
+// restore sets the duration and loudness of index entry e of path, if they're cached for
+// e's modification time and size.
+func (dc *diskCache) restore(path string, e *indexEntry) {
+	if dc == nil {
+		return
+	}
+	dc.Lock()
+	defer dc.Unlock()
+	fr, ok := dc.files[path]
+	if !ok || !fr.ModTime.Equal(e.modTime) || fr.Size != e.size {
+		return
+	}
+	e.duration, e.measured = fr.Duration, fr.Measured
+	e.loudness = fr.Loudness
+}
+
+// putEntry records the duration and loudness of index entry e of path.
+func (dc *diskCache) putEntry(path string, e *indexEntry) {
+	if dc == nil {
+		return
+	}
+	dc.Lock()
+	fr := dc.record(path, e.modTime)
+	if fr.Size != e.size {
+		fr.Size, fr.Measured, fr.Loudness = e.size, false, nil
+	}
+	if e.measured {
+		fr.Duration, fr.Measured = e.duration, true
+	}
+	if e.loudness != nil {
+		fr.Loudness = e.loudness
+	}
+	dc.Unlock()
+}
+
+// persist saves dc every 5 minutes if it changed.
+func (dc *diskCache) persist(idx *fileIndex) {
+	for {
+		time.Sleep(5 * time.Minute)
+		dc.save(idx)
+	}
+}
+
+// save writes dc to its file if it changed. Records of files not found by the last
+// complete scan of idx are dropped, unless there was none yet.
+func (dc *diskCache) save(idx *fileIndex) {
+	if dc == nil {
+		return
+	}
+	idx.Lock()
+	var found map[string]bool
+	if idx.gen > 0 {
+		found = make(map[string]bool, len(idx.entries))
+		for path := range idx.entries {
+			found[path] = true
+		}
+	}
+	idx.Unlock()
+
+	dc.Lock()
+	if !dc.dirty {
+		dc.Unlock()
+		return
+	}
+	cf := cacheFile{Version: cacheVersion, Codec: *codec, Files: make(map[string]*fileRecord, len(dc.files))}
+	for path, fr := range dc.files {
+		if found != nil && !found[path] {
+			delete(dc.files, path)
+			continue
+		}
+		r := *fr
+		cf.Files[path] = &r
+	}
+	dc.dirty = false
+	dc.Unlock()
+
+	if err := writeGob(dc.path, cf); err != nil {
+		log.Printf("Error: saving probe cache file %#v failed: %v", dc.path, err)
+	}
+}
+
+// writeGob writes v gzipped gob to path, replacing it atomically.
+func writeGob(path string, v interface{}) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".boringstreamer")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(tmp)
+	err = gob.NewEncoder(zw).Encode(v)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
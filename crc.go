@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/fgergo/mp3"
+)
+
+// crc16 is the CRC of mp3 frames: polynomial 0x8005, initial value 0xffff.
+func crc16(crc uint16, b []byte) uint16 {
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crcOK reports whether the CRC of frame buf (with header h) is correct. Only Layer III frames
+// are checked, the CRC covers the last two header bytes and the side information.
+// Frames without CRC or of other layers are reported correct.
+func crcOK(h mp3.FrameHeader, buf []byte) bool {
+	if !h.Protection() || h.Layer() != mp3.Layer3 {
+		return true
+	}
+	side := sideInfoLen(h)
+	if len(buf) < 6+side {
+		return false
+	}
+	crc := crc16(0xffff, buf[2:4])
+	crc = crc16(crc, buf[6:6+side])
+	return crc == uint16(buf[4])<<8|uint16(buf[5])
+}
+
+// sideInfoLen returns the length of the side information of Layer III frames with header h.
+func sideInfoLen(h mp3.FrameHeader) int {
+	mono := h.ChannelMode() == mp3.SingleChannel
+	switch {
+	case h.Version() == mp3.MPEG1 && !mono:
+		return 32
+	case h.Version() == mp3.MPEG1 || !mono:
+		return 17
+	}
+	return 9
+}
+
+// isInfoFrame reports whether frame buf (with header h) is a Xing, Info or VBRI header frame,
+// a silent frame carrying VBR metadata at the start of the file.
+func isInfoFrame(h mp3.FrameHeader, buf []byte) bool {
+	if h.Layer() != mp3.Layer3 {
+		return false
+	}
+	off := 4 + sideInfoLen(h)
+	if h.Protection() {
+		off += 2
+	}
+	if len(buf) >= off+4 {
+		if tag := string(buf[off : off+4]); tag == "Xing" || tag == "Info" {
+			return true
+		}
+	}
+	return len(buf) >= 40 && string(buf[36:40]) == "VBRI" // always 32 bytes after the header
+}
+
+// crcLimit is the number or percentage of frames with CRC errors a file may have, see -maxcrcerrors.
+type crcLimit struct {
+	n       int
+	percent bool
+}
+
+// parseCRCLimit parses -maxcrcerrors, e.g. "10" or "5%".
+func parseCRCLimit(s string) (crcLimit, error) {
+	l := crcLimit{percent: strings.HasSuffix(s, "%")}
+	n, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+	if err != nil || n < 0 || (l.percent && n > 100) {
+		return l, errors.New("use number of frames (e.g. 10) or percentage (e.g. 5%)")
+	}
+	l.n = n
+	return l, nil
+}
+
+// exceeded reports whether errs frames with CRC errors of frames decoded exceed l.
+// Percentages are checked after 100 frames.
+func (l crcLimit) exceeded(errs, frames int) bool {
+	if !l.percent {
+		return errs > l.n
+	}
+	return frames >= 100 && errs*100 > l.n*frames
+}
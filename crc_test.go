@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fgergo/mp3"
+)
+
+// crcFrame returns a frame protected by CRC, the CRC is correct if ok is set.
+func crcFrame(ok bool) []byte {
+	f := testFrame(0xff, 0xfa, 0x90, 0x00) // MPEG1 Layer III, CRC, 128kbps, 44.1kHz, stereo
+	f[6] = 0x42                            // side information isn't all zeros
+	crc := crc16(0xffff, f[2:4])
+	crc = crc16(crc, f[6:6+32])
+	if !ok {
+		crc ^= 1
+	}
+	f[4], f[5] = byte(crc>>8), byte(crc)
+	return f
+}
+
+func TestCRCOK(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		ok    bool
+	}{
+		{"correct", crcFrame(true), true},
+		{"wrong", crcFrame(false), false},
+		{"no CRC", frame44k, true},
+		{"truncated", crcFrame(true)[:20], false},
+	} {
+		if got := crcOK(mp3.FrameHeader(tc.frame[:4]), tc.frame); got != tc.ok {
+			t.Errorf("%v: crcOK %v, want %v", tc.name, got, tc.ok)
+		}
+	}
+}
+
+func TestParseCRCLimit(t *testing.T) {
+	for _, tc := range []struct {
+		s   string
+		l   crcLimit
+		err bool
+	}{
+		{"0", crcLimit{0, false}, false},
+		{"10", crcLimit{10, false}, false},
+		{"5%", crcLimit{5, true}, false},
+		{"100%", crcLimit{100, true}, false},
+		{"101%", crcLimit{}, true},
+		{"-1", crcLimit{}, true},
+		{"", crcLimit{}, true},
+		{"ten", crcLimit{}, true},
+	} {
+		l, err := parseCRCLimit(tc.s)
+		if (err != nil) != tc.err {
+			t.Errorf("parseCRCLimit(%#v) error %v, want error %v", tc.s, err, tc.err)
+			continue
+		}
+		if err == nil && l != tc.l {
+			t.Errorf("parseCRCLimit(%#v) = %+v, want %+v", tc.s, l, tc.l)
+		}
+	}
+}
+
+func TestMaxCRCErrors(t *testing.T) {
+	defer func(s string, l crcLimit) { *maxCRCErrors, crcErrorLimit = s, l }(*maxCRCErrors, crcErrorLimit)
+
+	// stream returns frames, the CRC of frames 0, every, 2*every... is wrong, none's if every is 0
+	stream := func(frames, every int) []byte {
+		var b []byte
+		for i := 0; i < frames; i++ {
+			b = append(b, crcFrame(every == 0 || i%every != 0)...)
+		}
+		return b
+	}
+	for _, tc := range []struct {
+		limit         string
+		frames, every int
+		played        int
+	}{
+		{"", 20, 5, 20}, // not checked
+		{"3", 20, 0, 20},
+		{"3", 15, 5, 12},      // 3 errors, below the threshold
+		{"3", 20, 5, 12},      // 4th error, rest of the file is skipped
+		{"0", 20, 5, 0},       // first error
+		{"10%", 200, 20, 190}, // 5%
+		{"10%", 200, 5, 80},   // 20%, checked after 100 frames
+	} {
+		*maxCRCErrors = tc.limit
+		if tc.limit != "" {
+			var err error
+			if crcErrorLimit, err = parseCRCLimit(tc.limit); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m := testMux()
+		_, n := decode(m, bytes.NewReader(stream(tc.frames, tc.every)), 0, false)
+		if n != tc.played {
+			t.Errorf("-maxcrcerrors %#v, %v frames, every %vth bad: %v frames played, want %v", tc.limit, tc.frames, tc.every, n, tc.played)
+		}
+	}
+}
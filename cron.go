@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronEntry plays file at the times matching a crontab(5) style schedule, see -cron.
+type cronEntry struct {
+	spec                          string // schedule, first 5 fields of the flag value
+	path                          string // absolute
+	minute, hour, dom, month, dow uint64 // bit n set: value n matches
+	domAny, dowAny                bool   // day of month or day of week is *
+}
+
+// cronList is the value of repeatable flag -cron.
+type cronList []*cronEntry
+
+func cronFlag(name, usage string) *cronList {
+	cl := new(cronList)
+	flag.Var(cl, name, usage)
+	return cl
+}
+
+func (cl *cronList) String() string {
+	if cl == nil {
+		return ""
+	}
+	var s []string
+	for _, e := range *cl {
+		s = append(s, e.spec+" "+e.path)
+	}
+	return strings.Join(s, ", ")
+}
+
+func (cl *cronList) Set(s string) error {
+	e, err := parseCron(s)
+	if err != nil {
+		return err
+	}
+	*cl = append(*cl, e)
+	return nil
+}
+
+// parseCron parses "minute hour day-of-month month day-of-week file", fields as in
+// crontab(5): *, numbers, ranges (1-5), lists (0,30) and steps (*/15, 8-18/2).
+// Day of week 0 and 7 are Sunday. If both day fields are restricted either matches.
+func parseCron(s string) (*cronEntry, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 6 {
+		return nil, errors.New("use \"minute hour day-of-month month day-of-week file\"")
+	}
+	rest := s
+	for i := 0; i < 5; i++ {
+		rest = strings.TrimLeft(rest, " \t")
+		rest = rest[len(fields[i]):]
+	}
+	e := &cronEntry{spec: strings.Join(fields[:5], " "), path: strings.TrimSpace(rest)}
+	var err error
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+		name     string
+	}{
+		{&e.minute, 0, 59, "minute"},
+		{&e.hour, 0, 23, "hour"},
+		{&e.dom, 1, 31, "day of month"},
+		{&e.month, 1, 12, "month"},
+		{&e.dow, 0, 7, "day of week"},
+	} {
+		if *f.bits, err = parseCronField(fields[i], f.min, f.max); err != nil {
+			return nil, fmt.Errorf("invalid %v %#v: %v", f.name, fields[i], err)
+		}
+	}
+	if e.dow&(1<<7) != 0 {
+		e.dow |= 1
+	}
+	e.domAny, e.dowAny = fields[2] == "*", fields[4] == "*"
+
+	if e.path, err = filepath.Abs(e.path); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(e.path); err != nil {
+		return nil, err
+	} else if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%v is not a regular file", e.path)
+	}
+	return e, nil
+}
+
+// parseCronField returns the values matched by field f as bits, values are between min and max.
+func parseCronField(f string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, errors.New("invalid step")
+			}
+			part, step = part[:i], n
+		}
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			r := strings.SplitN(part, "-", 2)
+			if lo, err = strconv.Atoi(r[0]); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(r) == 2 {
+				if hi, err = strconv.Atoi(r[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				hi = max // e.g. 5/15: from 5 on
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("out of range %v-%v", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first time after t matching e, zero time if there's none within 5 years.
+func (e *cronEntry) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case e.month&(1<<uint(t.Month())) == 0:
+			t = later(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+		case !e.dayMatches(t):
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+		case e.hour&(1<<uint(t.Hour())) == 0:
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())) // not Truncate, it's UTC based
+		case e.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// later returns u if it's after t, else the start of the next hour after t. time.Date moves
+// local times skipped by a clock change backwards, e.g. 02:00 at a DST start to 01:00.
+func later(t, u time.Time) time.Time {
+	if u.After(t) {
+		return u
+	}
+	return t.Add(time.Duration(60-t.Minute()) * time.Minute)
+}
+
+func (e *cronEntry) dayMatches(t time.Time) bool {
+	dom := e.dom&(1<<uint(t.Day())) != 0
+	dow := e.dow&(1<<uint(t.Weekday())) != 0
+	if e.domAny || e.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// scheduledEvent is the next -cron event on /status.
+type scheduledEvent struct {
+	At   time.Time `json:"at"`
+	Path string    `json:"path"`
+}
+
+// schedule plays the files of entries at their scheduled times like /interrupt does, at
+// the next frame, then the interrupted track goes on. If an interrupt or scheduled file is
+// playing or pending the file is played after it, or skipped with -cronbusy skip.
+func (m *mux) schedule(entries cronList) {
+	for {
+		var next time.Time
+		var due []*cronEntry // entries due at next
+		now := time.Now()
+		for _, e := range entries {
+			t := e.next(now)
+			switch {
+			case t.IsZero():
+			case next.IsZero() || t.Before(next):
+				next, due = t, []*cronEntry{e}
+			case t.Equal(next):
+				due = append(due, e)
+			}
+		}
+		if next.IsZero() {
+			return // nothing matches, e.g. February 30
+		}
+		m.Lock()
+		m.nextScheduled = &scheduledEvent{next, due[0].path}
+		m.Unlock()
+
+		select {
+		case <-time.After(time.Until(next)):
+		case <-m.stopping:
+			return
+		}
+		for _, e := range due {
+			if !m.inject(e.path) {
+				return
+			}
+		}
+	}
+}
+
+// inject plays the file at path as a scheduled interrupt, false if m stopped.
+func (m *mux) inject(path string) bool {
+	for m.injecting() {
+		if *cronBusy == "skip" {
+			if *verbose {
+				fmt.Fprintf(infoOut, "Skipped scheduled %v, an interrupt is playing\n", path)
+			}
+			return true
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-m.stopping:
+			return false
+		}
+	}
+	t, err := openTrack(path, nil)
+	if err != nil {
+		log.Printf("Error: playing scheduled %v failed: %v", path, err)
+		return true
+	}
+	t.source = fromScheduled
+	select {
+	case m.interrupt <- t:
+	case <-m.stopping:
+		t.c.Close()
+		return false
+	}
+	return true
+}
+
+// injecting reports whether an interrupt or a scheduled file is playing or pending.
+func (m *mux) injecting() bool {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.interrupt) > 0 || m.playing.Source == fromInterrupt || m.playing.Source == fromScheduled
+}
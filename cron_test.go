@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	for _, tc := range []struct {
+		f        string
+		min, max int
+		bits     uint64
+		err      bool
+	}{
+		{"*", 0, 7, 0xff, false},
+		{"5", 0, 59, 1 << 5, false},
+		{"1-3", 1, 12, 1<<1 | 1<<2 | 1<<3, false},
+		{"0,30", 0, 59, 1 | 1<<30, false},
+		{"*/15", 0, 59, 1 | 1<<15 | 1<<30 | 1<<45, false},
+		{"8-18/5", 0, 23, 1<<8 | 1<<13 | 1<<18, false},
+		{"50/5", 0, 59, 1<<50 | 1<<55, false},
+		{"60", 0, 59, 0, true},
+		{"0", 1, 31, 0, true},
+		{"5-3", 0, 59, 0, true},
+		{"*/0", 0, 59, 0, true},
+		{"x", 0, 59, 0, true},
+		{"", 0, 59, 0, true},
+	} {
+		bits, err := parseCronField(tc.f, tc.min, tc.max)
+		if (err != nil) != tc.err {
+			t.Errorf("parseCronField(%#v, %v, %v) error %v, want error %v", tc.f, tc.min, tc.max, err, tc.err)
+			continue
+		}
+		if bits != tc.bits {
+			t.Errorf("parseCronField(%#v, %v, %v) = %#x, want %#x", tc.f, tc.min, tc.max, bits, tc.bits)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "top of the hour.mp3")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		s    string
+		spec string
+		err  bool
+	}{
+		{"0 * * * * " + file, "0 * * * *", false},
+		{"0  9\t* * 1-5   " + file, "0 9 * * 1-5", false},
+		{"0 * * *" + file, "", true},          // 5 fields
+		{"61 * * * * " + file, "", true},      // minute out of range
+		{"0 * * * * " + dir, "", true},        // not a regular file
+		{"0 * * * * " + file + "x", "", true}, // missing
+	} {
+		e, err := parseCron(tc.s)
+		if (err != nil) != tc.err {
+			t.Errorf("parseCron(%#v) error %v, want error %v", tc.s, err, tc.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if e.spec != tc.spec || e.path != file {
+			t.Errorf("parseCron(%#v) = %#v %#v, want %#v %#v", tc.s, e.spec, e.path, tc.spec, file)
+		}
+	}
+
+	e, err := parseCron("0 0 * * 7 " + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.dow&1 == 0 {
+		t.Errorf("day of week 7 doesn't match Sunday")
+	}
+}
+
+// testCronEntry returns the entry of spec playing file.
+func testCronEntry(t *testing.T, spec string) *cronEntry {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "id.mp3")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := parseCron(spec + " " + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestCronNext(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+1800)
+	chatham := time.FixedZone("CHAST", 12*3600+2700)
+	for _, tc := range []struct {
+		spec     string
+		from, at time.Time
+	}{
+		{"0 * * * *", time.Date(2026, 10, 16, 10, 15, 0, 0, time.UTC), time.Date(2026, 10, 16, 11, 0, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2026, 10, 16, 11, 0, 0, 0, time.UTC), time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 16, 10, 15, 30, 0, time.UTC), time.Date(2026, 10, 16, 10, 30, 0, 0, time.UTC)},
+		{"30 8 * * 1-5", time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)}, // Friday to Monday
+		{"0 0 1 1 *", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)}, // Friday or the 13th
+		{"0 0 29 2 *", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), time.Time{}},
+		// offsets of half and quarter hours
+		{"0 9 * * *", time.Date(2026, 10, 16, 7, 10, 0, 0, ist), time.Date(2026, 10, 16, 9, 0, 0, 0, ist)},
+		{"0 * * * *", time.Date(2026, 10, 16, 7, 10, 0, 0, ist), time.Date(2026, 10, 16, 8, 0, 0, 0, ist)},
+		{"15 9 * * *", time.Date(2026, 10, 16, 7, 50, 0, 0, chatham), time.Date(2026, 10, 16, 9, 15, 0, 0, chatham)},
+	} {
+		e := testCronEntry(t, tc.spec)
+		if got := e.next(tc.from); !got.Equal(tc.at) {
+			t.Errorf("%#v next(%v) = %v, want %v", tc.spec, tc.from, got, tc.at)
+		}
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	edt := time.FixedZone("EDT", -4*3600)
+	est := time.FixedZone("EST", -5*3600)
+	for _, tc := range []struct {
+		spec     string
+		from, at time.Time
+	}{
+		// 2026-03-08 02:00 EST is 03:00 EDT, 02:30 doesn't exist that day
+		{"30 2 * * *", time.Date(2026, 3, 8, 0, 0, 0, 0, ny), time.Date(2026, 3, 9, 2, 30, 0, 0, edt)},
+		{"0 * * * *", time.Date(2026, 3, 8, 1, 30, 0, 0, ny), time.Date(2026, 3, 8, 3, 0, 0, 0, edt)},
+		{"0 4 * * *", time.Date(2026, 3, 8, 0, 0, 0, 0, ny), time.Date(2026, 3, 8, 4, 0, 0, 0, edt)},
+		// 2026-11-01 02:00 EDT is 01:00 EST, 01:30 happens twice
+		{"30 1 * * *", time.Date(2026, 11, 1, 0, 0, 0, 0, ny), time.Date(2026, 11, 1, 1, 30, 0, 0, edt)},
+		{"30 1 * * *", time.Date(2026, 11, 1, 1, 30, 0, 0, edt), time.Date(2026, 11, 1, 1, 30, 0, 0, est)},
+		{"0 3 * * *", time.Date(2026, 11, 1, 0, 0, 0, 0, ny), time.Date(2026, 11, 1, 3, 0, 0, 0, est)},
+	} {
+		e := testCronEntry(t, tc.spec)
+		if got := e.next(tc.from.In(ny)); !got.Equal(tc.at) {
+			t.Errorf("%#v next(%v) = %v, want %v", tc.spec, tc.from.In(ny), got, tc.at)
+		}
+	}
+
+	// DST starts at midnight, 2026-09-06 00:00 is 01:00
+	scl, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, tc := range []struct {
+		spec     string
+		from, at time.Time
+	}{
+		{"0 12 * * *", time.Date(2026, 9, 5, 13, 0, 0, 0, scl), time.Date(2026, 9, 6, 12, 0, 0, 0, scl)},
+		{"30 0 * * *", time.Date(2026, 9, 5, 13, 0, 0, 0, scl), time.Date(2026, 9, 7, 0, 30, 0, 0, scl)},
+		{"0 * * * *", time.Date(2026, 9, 5, 23, 0, 0, 0, scl), time.Date(2026, 9, 6, 1, 0, 0, 0, scl)},
+	} {
+		e := testCronEntry(t, tc.spec)
+		if got := e.next(tc.from); !got.Equal(tc.at) {
+			t.Errorf("%#v next(%v) = %v, want %v", tc.spec, tc.from, got, tc.at)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Duplicates are found by -dedupe:
+//	tags      same artist and title in ID3v2 tags, files without title are never duplicates
+//	filename  same file name without extension
+// Names are compared case insensitively, letters and digits only, e.g. "01 - Song.mp3" and
+// "01_song.MP3" match. Of duplicates the one with the highest bitrate (of its first frame) is
+// played, ties are broken by the alphabetically first path.
+
+// dedupeKey returns the -dedupe key of the file at path with probe p.
+// Empty key means the file has no duplicates.
+func dedupeKey(path string, p probe) string {
+	switch *dedupe {
+	case "tags":
+		if p.title != "" {
+			return normalize(p.artist) + "\x00" + normalize(p.title)
+		}
+	case "filename":
+		name := strings.TrimSuffix(strings.ToLower(filepath.Base(path)), ".gz")
+		return normalize(strings.TrimSuffix(name, filepath.Ext(name)))
+	}
+	return ""
+}
+
+// normalize returns s lowercase, letters and digits only.
+func normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+// dedupe returns files without duplicates, keeping the order of files.
+func (idx *fileIndex) dedupe(files []string) []string {
+	modTimes := make(map[string]time.Time)
+	idx.Lock()
+	for _, f := range files {
+		if e, ok := idx.entries[f]; ok {
+			modTimes[f] = e.modTime
+		}
+	}
+	idx.Unlock()
+
+	probes := make(map[string]probe)
+	keys := make(map[string]string)
+	best := make(map[string]string) // key: path of best copy
+	for _, f := range files {
+		modTime, ok := modTimes[f]
+		if !ok {
+			continue
+		}
+		p := idx.probes.get(f, modTime)
+		key := dedupeKey(f, p)
+		probes[f], keys[f] = p, key
+		if key == "" {
+			continue
+		}
+		b, ok := best[key]
+		if !ok {
+			best[key] = f
+			continue
+		}
+		if bp := probes[b]; p.kbps > bp.kbps || (p.kbps == bp.kbps && f < b) {
+			best[key] = f
+		}
+	}
+	var deduped []string
+	for _, f := range files {
+		if key := keys[f]; key != "" && best[key] != f {
+			if debugging {
+				log.Printf("Skipping duplicate \"%v\" of \"%v\"", f, best[key])
+			}
+			continue
+		}
+		deduped = append(deduped, f)
+	}
+	return deduped
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// egressLimit is the egress budget of all streams together, see -maxegress.
+type egressLimit struct {
+	rate  int64 // bytes per second, 0: unlimited
+	month int64 // bytes per calendar month, 0: unlimited
+}
+
+// parseEgress parses -maxegress, e.g. "2MB/s,500GB/month". Units are B, KB, MB, GB
+// and TB, powers of 1000.
+func parseEgress(s string) (egressLimit, error) {
+	var l egressLimit
+	if s == "" {
+		return l, nil
+	}
+	for _, e := range strings.Split(s, ",") {
+		i := strings.Index(e, "/")
+		if i < 0 {
+			return l, fmt.Errorf("missing /s or /month in %#v", e)
+		}
+		amount, per := strings.ToUpper(strings.TrimSpace(e[:i])), e[i+1:]
+		mult := int64(1)
+		for _, u := range []struct {
+			suffix string
+			mult   int64
+		}{{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"B", 1}} {
+			if strings.HasSuffix(amount, u.suffix) {
+				amount, mult = strings.TrimSuffix(amount, u.suffix), u.mult
+				break
+			}
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		if err != nil || n <= 0 {
+			return l, fmt.Errorf("invalid amount in %#v", e)
+		}
+		switch per {
+		case "s":
+			l.rate = int64(n * float64(mult))
+		case "month":
+			l.month = int64(n * float64(mult))
+		default:
+			return l, fmt.Errorf("invalid period %#v in %#v, use s or month", per, e)
+		}
+	}
+	return l, nil
+}
+
+// egressMeter counts bytes sent to clients of all streams. The monthly total
+// is saved to path if set, so it survives restarts, and starts from 0 in
+// each calendar month (local time).
+type egressMeter struct {
+	sync.Mutex
+
+	limit      egressLimit
+	buckets    [10]egressBucket // bytes sent in the last 10 seconds
+	month      string           // e.g. 2006-01
+	monthBytes int64
+	path       string // state file, empty: not persisted
+}
+
+type egressBucket struct {
+	sec int64 // unix time of bucket
+	n   int64
+}
+
+// egress counts all bytes streamed, see -maxegress.
+var egress = loadEgress("", egressLimit{})
+
+// loadEgress returns a meter enforcing limit, the monthly total is persisted in
+// the state file at path. A missing or unreadable state file starts from 0.
+func loadEgress(path string, limit egressLimit) *egressMeter {
+	em := &egressMeter{limit: limit, month: time.Now().Format("2006-01"), path: path}
+	if path == "" {
+		return em
+	}
+	lines, err := readLines(path)
+	if err != nil || len(lines) < 1 {
+		if debugging && err != nil {
+			log.Printf("Ignoring egress state file %#v, err=%v", path, err)
+		}
+		return em
+	}
+	var month string
+	var n int64
+	if _, err := fmt.Sscan(lines[0], &month, &n); err == nil && month == em.month {
+		em.monthBytes = n
+	}
+	return em
+}
+
+// add counts n bytes sent.
+func (em *egressMeter) add(n int) {
+	now := time.Now()
+	em.Lock()
+	em.rollover(now)
+	em.monthBytes += int64(n)
+	sec := now.Unix()
+	b := &em.buckets[sec%int64(len(em.buckets))]
+	if b.sec != sec {
+		b.sec, b.n = sec, 0
+	}
+	b.n += int64(n)
+	em.Unlock()
+}
+
+// rollover starts counting a new month if now is in another month. Called with em locked.
+func (em *egressMeter) rollover(now time.Time) {
+	if month := now.Format("2006-01"); month != em.month {
+		em.month, em.monthBytes = month, 0
+	}
+}
+
+// stats returns bytes sent per second in the last 10 seconds and bytes sent this month.
+func (em *egressMeter) stats() (rate, month int64) {
+	now := time.Now()
+	em.Lock()
+	defer em.Unlock()
+	em.rollover(now)
+	for _, b := range em.buckets {
+		if now.Unix()-b.sec < int64(len(em.buckets)) {
+			rate += b.n
+		}
+	}
+	return rate / int64(len(em.buckets)), em.monthBytes
+}
+
+// exceeded reports whether the rate or the monthly limit is exceeded.
+func (em *egressMeter) exceeded() (rate, month bool) {
+	r, m := em.stats()
+	return em.limit.rate > 0 && r > em.limit.rate, em.limit.month > 0 && m >= em.limit.month
+}
+
+// save writes the monthly total to the state file if set.
+func (em *egressMeter) save() {
+	if em.path == "" {
+		return
+	}
+	em.Lock()
+	line := fmt.Sprintf("%v %v", em.month, em.monthBytes)
+	em.Unlock()
+	if err := writeLines(em.path, []string{line}); err != nil && debugging {
+		log.Printf("Saving egress state file %#v failed, err=%v", em.path, err)
+	}
+}
+
+// enforce saves the monthly total every minute. With -egresspolicy drop it disconnects
+// the newest client of muxes while the rate limit is exceeded, one per 10 seconds as
+// the rate is measured over 10 seconds, and all clients once the monthly limit is reached.
+func (em *egressMeter) enforce(muxes []*mux) {
+	var lastDrop time.Time
+	for i := 1; ; i++ {
+		time.Sleep(1 * time.Second)
+		if i%60 == 0 {
+			em.save()
+		}
+		if *egressPolicy != "drop" {
+			continue
+		}
+		overRate, overMonth := em.exceeded()
+		if !overRate && !overMonth {
+			continue
+		}
+		newest, newestQID := (*mux)(nil), -1
+		var started time.Time
+		for _, m := range muxes {
+			m.Lock()
+			for qid, c := range m.clients {
+				if c.internal {
+					continue
+				}
+				switch {
+				case overMonth:
+					c.kicked = true
+				case newestQID < 0 || c.started.After(started):
+					newest, newestQID, started = m, qid, c.started
+				}
+			}
+			m.Unlock()
+		}
+		if overRate && !overMonth && newest != nil && time.Since(lastDrop) >= time.Duration(len(em.buckets))*time.Second {
+			lastDrop = time.Now()
+			newest.kick(newestQID)
+			if *verbose {
+				fmt.Fprintf(infoOut, "Egress rate exceeded, disconnecting newest connection (qid: %v), see -maxegress\n", newestQID)
+			}
+		}
+	}
+}
+
+// egressWriter counts bytes written to a response as egress.
+type egressWriter struct {
+	http.ResponseWriter
+}
+
+func (ew egressWriter) Write(b []byte) (int, error) {
+	n, err := ew.ResponseWriter.Write(b)
+	egress.add(n)
+	return n, err
+}
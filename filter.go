@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+)
+
+// tagFilter is a repeatable flag of ID3v2 tag filters. A file matches if its tag
+// contains any of the filters, case insensitively. Files match all tag filters
+// to be played, e.g. -genre jazz -genre blues -artist davis plays jazz or blues by Davis.
+// Files without ID3v2 tags don't match. Numeric ID3v1 genres (e.g. "(8)") are not translated.
+type tagFilter []string
+
+// tagFlag defines a repeatable tag filter flag.
+func tagFlag(name, usage string) *tagFilter {
+	tf := new(tagFilter)
+	flag.Var(tf, name, usage)
+	return tf
+}
+
+func (tf *tagFilter) String() string {
+	if tf == nil {
+		return ""
+	}
+	return strings.Join(*tf, ", ")
+}
+
+func (tf *tagFilter) Set(s string) error {
+	*tf = append(*tf, strings.ToLower(s))
+	return nil
+}
+
+// match reports whether v contains any of the filters, or there are no filters.
+func (tf tagFilter) match(v string) bool {
+	if len(tf) == 0 {
+		return true
+	}
+	v = strings.ToLower(v)
+	for _, f := range tf {
+		if strings.Contains(v, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the file at path matches -genre and -artist.
+func (idx *fileIndex) matches(path string, info os.FileInfo) bool {
+	if len(*genres) == 0 && len(*artists) == 0 {
+		return true
+	}
+	p := idx.probes.get(path, info.ModTime())
+	return genres.match(p.genre) && artists.match(p.artist)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/fgergo/mp3"
+)
+
+// decoderDelay is the delay of mp3 decoders in samples, gapless players skip it
+// in addition to the encoder delay.
+const decoderDelay = 529
+
+// gaplessInfo returns the number of audio frames and the encoder delay and padding
+// in samples from the Xing/Info header frame buf (with header h) of a LAME encoded file.
+// Returns ok false if buf has no LAME tag, frames is 0 if the header doesn't count frames.
+func gaplessInfo(h mp3.FrameHeader, buf []byte) (frames, delay, padding int, ok bool) {
+	off := 4 + sideInfoLen(h)
+	if h.Protection() {
+		off += 2
+	}
+	if len(buf) < off+8 {
+		return 0, 0, 0, false
+	}
+	if tag := string(buf[off : off+4]); tag != "Xing" && tag != "Info" {
+		return 0, 0, 0, false
+	}
+	flags := binary.BigEndian.Uint32(buf[off+4:])
+	off += 8
+	if flags&0x1 != 0 {
+		if len(buf) < off+4 {
+			return 0, 0, 0, false
+		}
+		frames = int(binary.BigEndian.Uint32(buf[off:]))
+		off += 4
+	}
+	if flags&0x2 != 0 { // bytes
+		off += 4
+	}
+	if flags&0x4 != 0 { // TOC
+		off += 100
+	}
+	if flags&0x8 != 0 { // quality
+		off += 4
+	}
+	if len(buf) < off+24 || string(buf[off:off+4]) != "LAME" {
+		return frames, 0, 0, false
+	}
+	b := buf[off+21:]
+	delay = int(b[0])<<4 | int(b[1])>>4
+	padding = int(b[1]&0x0f)<<8 | int(b[2])
+	return frames, delay, padding, true
+}
+
+// gaplessTrim returns the frames at the start and the index of the first frame at the
+// end to drop, made up only of encoder delay or padding. Frames partly made up of them
+// are kept, compressed frames can't be cut, so some silence remains at the junction.
+// end is 0 if the number of frames is unknown.
+func gaplessTrim(h mp3.FrameHeader, frames, delay, padding int) (start, end int) {
+	spf := 1152 // samples per Layer III frame
+	if h.Version() != mp3.MPEG1 {
+		spf = 576
+	}
+	start = (delay + decoderDelay) / spf
+	if frames > 0 && padding > decoderDelay {
+		end = frames - (padding-decoderDelay)/spf
+	}
+	return start, end
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/fgergo/mp3"
+)
+
+// infoFrame returns an Info header frame of frames audio frames, with a LAME tag of
+// encoder delay and padding if lame is set.
+func infoFrame(frames, delay, padding int, lame bool) []byte {
+	f := append([]byte(nil), frame44k...)
+	off := 4 + 32
+	copy(f[off:], "Info")
+	binary.BigEndian.PutUint32(f[off+4:], 0x1) // frames
+	binary.BigEndian.PutUint32(f[off+8:], uint32(frames))
+	if lame {
+		copy(f[off+12:], "LAME3.100")
+		b := f[off+12+21:]
+		b[0], b[1], b[2] = byte(delay>>4), byte(delay<<4)|byte(padding>>8&0x0f), byte(padding)
+	}
+	return f
+}
+
+func TestGaplessInfo(t *testing.T) {
+	xing := append([]byte(nil), frame44k...)
+	copy(xing[36:], "Xing")
+	binary.BigEndian.PutUint32(xing[40:], 0x1|0x2|0x4|0x8)
+	binary.BigEndian.PutUint32(xing[44:], 1234)
+	lame := xing[44+4+4+100+4:]
+	copy(lame, "LAME")
+	lame[21], lame[22], lame[23] = 0x24, 0x01, 0x23 // delay 576, padding 291
+
+	for _, tc := range []struct {
+		name                   string
+		frame                  []byte
+		frames, delay, padding int
+		ok                     bool
+	}{
+		{"Info and LAME", infoFrame(100, 2000, 3000, true), 100, 2000, 3000, true},
+		{"Info without LAME", infoFrame(100, 0, 0, false), 100, 0, 0, false},
+		{"Xing with TOC", xing, 1234, 576, 291, true},
+		{"audio", frame44k, 0, 0, 0, false},
+		{"truncated LAME tag", infoFrame(100, 2000, 3000, true)[:60], 100, 0, 0, false},
+	} {
+		frames, delay, padding, ok := gaplessInfo(mp3.FrameHeader(tc.frame[:4]), tc.frame)
+		if frames != tc.frames || delay != tc.delay || padding != tc.padding || ok != tc.ok {
+			t.Errorf("%v: %v frames, delay %v, padding %v, ok %v, want %v, %v, %v, %v", tc.name, frames, delay, padding, ok, tc.frames, tc.delay, tc.padding, tc.ok)
+		}
+	}
+}
+
+func TestGaplessTrim(t *testing.T) {
+	mpeg2 := mp3.FrameHeader([]byte{0xff, 0xf3, 0x90, 0x00})
+	for _, tc := range []struct {
+		h                      mp3.FrameHeader
+		frames, delay, padding int
+		start, end             int
+	}{
+		{mp3.FrameHeader(frame44k[:4]), 100, 576, 1000, 0, 100}, // less than a frame
+		{mp3.FrameHeader(frame44k[:4]), 100, 2000, 3000, 2, 98}, // 2529 and 2471 samples
+		{mp3.FrameHeader(frame44k[:4]), 0, 2000, 3000, 2, 0},    // number of frames unknown
+		{mp3.FrameHeader(frame44k[:4]), 100, 0, 500, 0, 0},      // padding within decoder delay
+		{mpeg2, 100, 2000, 3000, 4, 96},                         // 576 samples per frame
+	} {
+		start, end := gaplessTrim(tc.h, tc.frames, tc.delay, tc.padding)
+		if start != tc.start || end != tc.end {
+			t.Errorf("%v %v frames, delay %v, padding %v: trim %v, %v, want %v, %v", tc.h.Version(), tc.frames, tc.delay, tc.padding, start, end, tc.start, tc.end)
+		}
+	}
+}
+
+// TestGaplessLoop plays a gapless file twice in a row like -loopfile does: frames of only
+// encoder delay or padding, and the Info frame aren't sent, the loop has no inserted silence.
+func TestGaplessLoop(t *testing.T) {
+	// 2 frames of delay, 16 of audio, 2 of padding
+	file := infoFrame(20, 2000, 3000, true)
+	for i := 0; i < 20; i++ {
+		f := append([]byte(nil), frame44k...)
+		if i >= 2 && i < 18 {
+			f[100] = byte(i) // audio
+		}
+		file = append(file, f...)
+	}
+
+	for _, tc := range []struct {
+		gapless bool
+		audio   []byte // of frames sent, 0: silence, 0xff: Info frame
+	}{
+		{true, []byte{2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17}},
+		{false, []byte{0xff, 0, 0, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 0, 0}},
+	} {
+		m := testMux()
+		var got []byte
+		for loop := 0; loop < 2; loop++ {
+			sent, _ := decode(m, bytes.NewReader(file), 0, tc.gapless)
+			for _, f := range sent {
+				if isInfoFrame(mp3.FrameHeader(f[:4]), f) {
+					got = append(got, 0xff)
+					continue
+				}
+				got = append(got, f[100])
+			}
+		}
+		want := append(append([]byte(nil), tc.audio...), tc.audio...)
+		if !bytes.Equal(got, want) {
+			t.Errorf("gapless %v: played %v, want %v", tc.gapless, got, want)
+		}
+	}
+}
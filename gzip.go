@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+)
+
+// isGzip reports whether f starts with the gzip magic bytes. f is rewound.
+func isGzip(f *os.File) bool {
+	var magic [2]byte
+	_, err := io.ReadFull(f, magic[:])
+	if _, serr := f.Seek(0, io.SeekStart); serr != nil {
+		return false
+	}
+	return err == nil && magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+// gzipFile closes both the decompressor and the file.
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+// openGzip returns the gzip compressed audio file f as a track.
+// The decompressed stream can't be seeked, so the ID3v2 tag is read and skipped in place.
+func openGzip(path string, f *os.File) (*track, error) {
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := bufio.NewReaderSize(zr, 1024*1024)
+
+	var tag *id3Tag
+	h, _ := r.Peek(10)
+	n := id3Size(h)
+	if n > 0 {
+		lr := io.LimitReader(r, n)
+		tag, _, err = readID3(lr)
+		if err != nil && debugging {
+			log.Printf("Ignoring ID3v2 tag of \"%v\", err=%v", path, err)
+		}
+		if _, err := io.Copy(io.Discard, lr); err != nil {
+			zr.Close()
+			f.Close()
+			return nil, err
+		}
+		if debugging {
+			log.Printf("Skipped %v bytes of ID3v2 tag in \"%v\"", n, path)
+		}
+	}
+
+	if err := checkFormat(r); err != nil {
+		zr.Close()
+		f.Close()
+		return nil, err
+	}
+
+	return &track{path: path, r: r, c: gzipFile{zr, f}, tag: tag, tagSize: n, source: fromLibrary}, nil
+}
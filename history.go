@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const recentlyPlayed = 100 // number of recently played files shuffle avoids
+
+// history is the list of recently played files, oldest first.
+// If path is set, the list is persisted, so shuffle avoids
+// recently played files across restarts too.
+type history struct {
+	sync.Mutex
+
+	files []string
+	max   int
+	path  string // state file, empty: not persisted
+}
+
+// loadHistory reads the state file at path. A missing or unreadable state file results in an empty history.
+func loadHistory(path string, max int) *history {
+	h := &history{max: max, path: path}
+	if path == "" {
+		return h
+	}
+
+	files, err := readLines(path)
+	if err != nil {
+		if debugging && !os.IsNotExist(err) {
+			log.Printf("Ignoring state file %#v, err=%v", path, err)
+		}
+		return h
+	}
+	h.files = files
+	h.trim()
+
+	return h
+}
+
+// add appends file to history and saves the state file if set.
+func (h *history) add(file string) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.files = append(h.files, file)
+	h.trim()
+	if h.path == "" {
+		return
+	}
+
+	if err := writeLines(h.path, h.files); err != nil && debugging {
+		log.Printf("Saving state file %#v failed, err=%v", h.path, err)
+	}
+}
+
+// recent returns the recently played files mapped to their position in history, oldest is 0.
+func (h *history) recent() map[string]int {
+	h.Lock()
+	defer h.Unlock()
+
+	r := make(map[string]int, len(h.files))
+	for i, f := range h.files {
+		r[f] = i
+	}
+	return r
+}
+
+// list returns the recently played files, oldest first.
+func (h *history) list() []string {
+	h.Lock()
+	defer h.Unlock()
+	return append([]string(nil), h.files...)
+}
+
+// last returns the most recently played file other than except, "" if there's none.
+func (h *history) last(except string) string {
+	h.Lock()
+	defer h.Unlock()
+
+	for i := len(h.files) - 1; i >= 0; i-- {
+		if h.files[i] != except {
+			return h.files[i]
+		}
+	}
+	return ""
+}
+
+// readLines returns the non-empty lines of file at path.
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, s.Err()
+}
+
+// writeLines replaces file at path with lines. The complete file is written
+// then renamed, a crash never leaves a partial file.
+func writeLines(path string, lines []string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".boringstreamer")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(strings.Join(lines, "\n") + "\n")
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (h *history) trim() {
+	if len(h.files) > h.max {
+		h.files = append([]string(nil), h.files[len(h.files)-h.max:]...)
+	}
+}
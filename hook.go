@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenersHook returns a mux.listeners callback running command with the number of
+// listeners appended, see -onlisteners. Commands run one at a time in the background,
+// changes while a command runs are coalesced: the next run gets the latest number.
+func listenersHook(command string) func(n int) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil
+	}
+	latest := make(chan int, 1)
+	go func() {
+		last := -1
+		for n := range latest {
+			if n == last {
+				continue
+			}
+			last = n
+			cmd := exec.Command(args[0], append(args[1:], strconv.Itoa(n))...)
+			cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+			if err := cmd.Run(); err != nil {
+				log.Printf("Error: -onlisteners command failed: %v", err)
+			}
+		}
+	}()
+
+	return func(n int) {
+		for {
+			select {
+			case latest <- n:
+				return
+			default:
+			}
+			select {
+			case <-latest: // replace older number not run yet
+			default:
+			}
+		}
+	}
+}
+
+// webhook POSTs the track being broadcast by m as JSON (as nowPlaying on /status) to url
+// whenever it changes, see -webhook. Track changes during a POST are coalesced, the next
+// POST has the latest track. A failed POST is tried at most 3 times, it doesn't affect
+// the broadcast.
+func (m *mux) webhook(url string) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	for {
+		m.Lock()
+		changed := m.trackChanged
+		m.Unlock()
+		select {
+		case <-changed:
+		case <-m.stopping:
+			return
+		}
+
+		m.Lock()
+		np := m.playing
+		m.Unlock()
+		b, err := json.Marshal(np)
+		if err != nil {
+			continue
+		}
+		for attempt := 1; attempt <= 3; attempt++ {
+			err = post(client, url, b)
+			if err == nil {
+				break
+			}
+			if debugging {
+				log.Printf("Webhook POST attempt %v failed, err=%v", attempt, err)
+			}
+			if attempt < 3 {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+		}
+		if err != nil {
+			log.Printf("Error: -webhook %v failed: %v", url, err)
+		}
+	}
+}
+
+// post POSTs JSON b to url. 5xx responses are errors worth retrying, others are not.
+func post(client *http.Client, url string, b []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%v", resp.Status)
+	}
+	return nil
+}
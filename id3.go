@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf16"
+)
+
+const maxID3Size = 16 * 1024 * 1024 // larger tags are skipped, not parsed
+
+// id3Tag holds the ID3v2 tag fields boringstreamer uses.
+type id3Tag struct {
+	title, artist, album, genre string
+	pictures                    []id3Picture
+}
+
+// id3Picture is an attached picture (APIC frame).
+type id3Picture struct {
+	mime string
+	kind byte // picture type, 3: front cover
+	data []byte
+}
+
+// cover returns the front cover or the first picture, nil if there are no pictures.
+func (t *id3Tag) cover() *id3Picture {
+	if t == nil || len(t.pictures) == 0 {
+		return nil
+	}
+	for i := range t.pictures {
+		if t.pictures[i].kind == 3 {
+			return &t.pictures[i]
+		}
+	}
+	return &t.pictures[0]
+}
+
+// syncsafe decodes a 28 bit integer stored in 4 bytes, 7 bits per byte.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// unsync removes unsynchronisation: 0xff 0x00 becomes 0xff.
+func unsync(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+// id3Size returns the size of the ID3v2 tag starting with header h, including header and footer.
+// Returns 0 if h is not an ID3v2 header.
+func id3Size(h []byte) int64 {
+	if len(h) < 10 || string(h[0:3]) != "ID3" || h[3] == 0xff || h[4] == 0xff || (h[6]|h[7]|h[8]|h[9])&0x80 != 0 {
+		return 0
+	}
+	n := int64(10 + syncsafe(h[6:10]))
+	if h[3] == 4 && h[5]&0x10 != 0 {
+		n += 10 // footer
+	}
+	return n
+}
+
+// readID3 parses the ID3v2 tag at the start of r. Returns the tag and its size in bytes,
+// the audio starts after size bytes. Returns nil, 0, nil if r doesn't start with an ID3v2 tag.
+// If the tag can't be parsed, size is still valid if it's not 0.
+// Versions 2.2, 2.3 and 2.4 are supported, compressed and encrypted frames are ignored.
+func readID3(r io.Reader) (*id3Tag, int64, error) {
+	var h [10]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, 0, err
+	}
+	n := id3Size(h[:])
+	if n == 0 {
+		return nil, 0, nil
+	}
+	version, flags, size := h[3], h[5], syncsafe(h[6:10])
+	if version < 2 || version > 4 {
+		return nil, n, errors.New("unsupported ID3v2 version")
+	}
+	if size > maxID3Size {
+		return nil, n, errors.New("ID3v2 tag too large")
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, 0, err
+	}
+	if flags&0x80 != 0 && version < 4 {
+		b = unsync(b) // whole tag, in 2.4 it's per frame
+	}
+	if flags&0x40 != 0 && version > 2 { // skip extended header
+		if len(b) < 4 {
+			return nil, n, errors.New("short ID3v2 extended header")
+		}
+		x := int(binary.BigEndian.Uint32(b[0:4])) + 4
+		if version == 4 {
+			x = syncsafe(b[0:4])
+		}
+		if x > len(b) {
+			return nil, n, errors.New("invalid ID3v2 extended header")
+		}
+		b = b[x:]
+	}
+
+	t := new(id3Tag)
+	idLen, hdrLen := 4, 10
+	if version == 2 {
+		idLen, hdrLen = 3, 6
+	}
+	for len(b) >= hdrLen && b[0] != 0 { // 0: padding
+		id := string(b[0:idLen])
+		var n int
+		var fflags uint16
+		switch version {
+		case 2:
+			n = int(b[3])<<16 | int(b[4])<<8 | int(b[5])
+		case 3:
+			n = int(binary.BigEndian.Uint32(b[4:8]))
+			fflags = binary.BigEndian.Uint16(b[8:10])
+		case 4:
+			n = syncsafe(b[4:8])
+			fflags = binary.BigEndian.Uint16(b[8:10])
+		}
+		if n > len(b)-hdrLen {
+			break // truncated tag, keep frames parsed so far
+		}
+		data := b[hdrLen : hdrLen+n]
+		b = b[hdrLen+n:]
+
+		switch {
+		case version == 3 && fflags&0x00c0 != 0: // compressed or encrypted
+			continue
+		case version == 4 && fflags&0x000c != 0:
+			continue
+		}
+		if version == 4 {
+			if fflags&0x0002 != 0 {
+				data = unsync(data)
+			}
+			if fflags&0x0001 != 0 { // data length indicator
+				if len(data) < 4 {
+					continue
+				}
+				data = data[4:]
+			}
+		}
+
+		switch id {
+		case "TIT2", "TT2":
+			t.title = id3Text(data)
+		case "TPE1", "TP1":
+			t.artist = id3Text(data)
+		case "TALB", "TAL":
+			t.album = id3Text(data)
+		case "TCON", "TCO":
+			t.genre = id3Text(data)
+		case "APIC", "PIC":
+			if p, ok := id3Pic(data, version == 2); ok {
+				t.pictures = append(t.pictures, p)
+			}
+		}
+	}
+
+	return t, n, nil
+}
+
+// skipID3 skips the ID3v2 tag at the start of r without parsing it, for streams that can't seek.
+// Returns the number of bytes skipped.
+func skipID3(r *bufio.Reader) (int64, error) {
+	h, err := r.Peek(10)
+	if err != nil {
+		return 0, nil // too short for a tag, let the decoder handle it
+	}
+	n := id3Size(h)
+	if n == 0 {
+		return 0, nil
+	}
+	return io.CopyN(io.Discard, r, n)
+}
+
+// id3Text decodes the first string of a text frame.
+func id3Text(data []byte) string {
+	if len(data) < 1 {
+		return ""
+	}
+	s, _ := id3String(data[0], data[1:])
+	return strings.TrimSpace(s)
+}
+
+// id3String decodes a zero terminated string in encoding enc from b.
+// Returns the string and the rest of b after the terminator.
+func id3String(enc byte, b []byte) (string, []byte) {
+	if enc == 1 || enc == 2 { // UTF-16 with BOM, UTF-16BE
+		end := len(b) &^ 1
+		rest := []byte(nil)
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				end, rest = i, b[i+2:]
+				break
+			}
+		}
+		s := b[:end]
+		bigEndian := enc == 2
+		if len(s) >= 2 && s[0] == 0xff && s[1] == 0xfe {
+			bigEndian, s = false, s[2:]
+		} else if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+			bigEndian, s = true, s[2:]
+		}
+		u := make([]uint16, len(s)/2)
+		for i := range u {
+			if bigEndian {
+				u[i] = binary.BigEndian.Uint16(s[2*i:])
+			} else {
+				u[i] = binary.LittleEndian.Uint16(s[2*i:])
+			}
+		}
+		return string(utf16.Decode(u)), rest
+	}
+
+	end, rest := len(b), []byte(nil)
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		end, rest = i, b[i+1:]
+	}
+	if enc == 3 { // UTF-8
+		return string(b[:end]), rest
+	}
+	// ISO-8859-1
+	r := make([]rune, end)
+	for i, c := range b[:end] {
+		r[i] = rune(c)
+	}
+	return string(r), rest
+}
+
+// artTypes are the picture types served on /nowplaying/art. Others, e.g. text/html or
+// image/svg+xml, could run scripts on the site.
+var artTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true}
+
+// id3Pic decodes an APIC frame, or a PIC frame of ID3v2.2 if v22 is set. Only pictures
+// declared and found by content to be of artTypes are accepted, the type found is used.
+func id3Pic(data []byte, v22 bool) (id3Picture, bool) {
+	var p id3Picture
+	if len(data) < 2 {
+		return p, false
+	}
+	enc, b := data[0], data[1:]
+	if v22 {
+		if len(b) < 3 {
+			return p, false
+		}
+		switch strings.ToUpper(string(b[0:3])) {
+		case "JPG":
+			p.mime = "image/jpeg"
+		case "PNG":
+			p.mime = "image/png"
+		default:
+			return p, false
+		}
+		b = b[3:]
+	} else {
+		i := bytes.IndexByte(b, 0)
+		if i < 0 {
+			return p, false
+		}
+		p.mime, b = strings.ToLower(string(b[:i])), b[i+1:]
+		switch {
+		case p.mime == "-->": // link to picture, not supported
+			return p, false
+		case p.mime == "" || p.mime == "jpg" || p.mime == "jpeg" || p.mime == "image/jpg":
+			p.mime = "image/jpeg"
+		case p.mime == "png":
+			p.mime = "image/png"
+		case !strings.Contains(p.mime, "/"):
+			p.mime = "image/" + p.mime
+		}
+	}
+	if len(b) < 1 {
+		return p, false
+	}
+	p.kind = b[0]
+	_, b = id3String(enc, b[1:]) // description
+	if len(b) == 0 {
+		return p, false
+	}
+	p.data = b
+	if !artTypes[p.mime] {
+		return p, false
+	}
+	p.mime = http.DetectContentType(b) // declared types are often wrong, e.g. png declared as jpeg
+	if !artTypes[p.mime] {
+		return p, false
+	}
+
+	return p, true
+}
+
+// id3Frames returns an ID3v2.3 tag with title, artist and album text frames, empty ones are left out.
+// Text is ISO-8859-1 if possible, UTF-16 big endian with BOM otherwise. Both avoid 0xff bytes
+// in common text, so decoders not skipping ID3 tags rarely find an mp3 frame sync in the tag.
+func id3Frames(title, artist, album string) []byte {
+	var frames bytes.Buffer
+	for _, f := range []struct{ id, text string }{{"TIT2", title}, {"TPE1", artist}, {"TALB", album}} {
+		if f.text == "" {
+			continue
+		}
+		data := []byte{0} // ISO-8859-1
+		for _, r := range f.text {
+			if r >= 0xff {
+				data = nil
+				break
+			}
+			data = append(data, byte(r))
+		}
+		if data == nil {
+			data = []byte{1, 0xfe, 0xff} // UTF-16 with big endian BOM
+			for _, u := range utf16.Encode([]rune(f.text)) {
+				data = append(data, byte(u>>8), byte(u))
+			}
+		}
+		frames.WriteString(f.id)
+		binary.Write(&frames, binary.BigEndian, uint32(len(data)))
+		frames.Write([]byte{0, 0}) // flags
+		frames.Write(data)
+	}
+
+	n := frames.Len()
+	tag := []byte{'I', 'D', '3', 3, 0, 0, byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+	return append(tag, frames.Bytes()...)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestID3Frames(t *testing.T) {
+	for _, tc := range []struct {
+		title, artist, album string
+	}{
+		{"Hello", "Art", "Album"},
+		{"Hello", "", ""},
+		{"", "", ""},
+		{"Árvíztűrő tükörfúrógép", "Ünnepi Kórus", "日本語"},       // UTF-16
+		{strings.Repeat("long title, ", 99) + "end", "Art", ""}, // syncsafe size over 127
+	} {
+		b := id3Frames(tc.title, tc.artist, tc.album)
+		if len(b) < 10 || string(b[:3]) != "ID3" || b[3] != 3 || b[4] != 0 || b[5] != 0 {
+			t.Errorf("%#v: header % x, want ID3v2.3 without flags", tc.title, b[:10])
+			continue
+		}
+		if (b[6]|b[7]|b[8]|b[9])&0x80 != 0 || syncsafe(b[6:10]) != len(b)-10 {
+			t.Errorf("%#v: size % x, want syncsafe %v", tc.title, b[6:10], len(b)-10)
+		}
+		tag, n, err := readID3(bytes.NewReader(b))
+		if err != nil || n != int64(len(b)) {
+			t.Errorf("%#v: readID3 read %v of %v bytes, err=%v", tc.title, n, len(b), err)
+			continue
+		}
+		if tag.title != tc.title || tag.artist != tc.artist || tag.album != tc.album {
+			t.Errorf("tag read back %#v %#v %#v, want %#v %#v %#v", tag.title, tag.artist, tag.album, tc.title, tc.artist, tc.album)
+		}
+	}
+}
+
+func TestInbandID3(t *testing.T) {
+	defer func(inband bool) { *inbandID3 = inband }(*inbandID3)
+	*inbandID3 = true
+
+	m := testStream(frame44k)
+	defer m.stop()
+	m.setPlaying(&track{path: "/music/first.mp3"})
+	srv := httptest.NewServer(streamHandler{mux: m})
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// tags are sent before the first frame of each track, between whole frames
+	r := bufio.NewReader(resp.Body)
+	var titles []string
+	for frames := 0; len(titles) < 2 || frames < 10; {
+		h, err := r.Peek(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(h[:3]) != "ID3" {
+			frame := make([]byte, len(frame44k))
+			if _, err := io.ReadFull(r, frame); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(frame, frame44k) {
+				t.Fatalf("after %v frames and tags %v: % x, want a frame", frames, titles, frame[:10])
+			}
+			frames++
+			if frames == 10 {
+				m.setPlaying(&track{path: "/music/second.mp3", tag: &id3Tag{title: "Second", artist: "Art"}})
+			}
+			continue
+		}
+		tag, _, err := readID3(r)
+		if err != nil {
+			t.Fatalf("reading tag after %v frames failed: %v", frames, err)
+		}
+		titles = append(titles, tag.title+" by "+tag.artist)
+		if len(titles) == 2 {
+			frames = 0
+		}
+	}
+	if titles[0] != "first by " || titles[1] != "Second by Art" {
+		t.Errorf("tags %#v, want first, then Second by Art", titles)
+	}
+}
+
+func TestID3Pic(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	jpeg := []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+	gif := []byte("GIF89a\x01\x00\x01\x00")
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")
+	html := []byte("<html><script>alert(1)</script></html>")
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`)
+	apic := func(mime string, data []byte) []byte {
+		b := append([]byte{0}, mime...)
+		b = append(b, 0, 3, 0) // front cover, no description
+		return append(b, data...)
+	}
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		v22   bool
+		mime  string // "": rejected
+	}{
+		{"png", apic("image/png", png), false, "image/png"},
+		{"jpeg", apic("image/jpeg", jpeg), false, "image/jpeg"},
+		{"jpg", apic("image/jpg", jpeg), false, "image/jpeg"},
+		{"gif", apic("image/gif", gif), false, "image/gif"},
+		{"webp", apic("image/webp", webp), false, "image/webp"},
+		{"no type", apic("", jpeg), false, "image/jpeg"},
+		{"png declared jpeg", apic("image/jpeg", png), false, "image/png"},
+		{"PIC", append([]byte{0, 'J', 'P', 'G', 3, 0}, jpeg...), true, "image/jpeg"},
+		{"html", apic("text/html", html), false, ""},
+		{"svg", apic("image/svg+xml", svg), false, ""},
+		{"html declared png", apic("image/png", html), false, ""},
+		{"svg declared jpeg", apic("image/jpeg", svg), false, ""},
+		{"link", apic("-->", []byte("http://example.com/cover.jpg")), false, ""},
+	} {
+		p, ok := id3Pic(tc.frame, tc.v22)
+		if ok != (tc.mime != "") || ok && p.mime != tc.mime {
+			t.Errorf("%v: ok %v, type %#v, want %#v", tc.name, ok, p.mime, tc.mime)
+		}
+	}
+
+	for _, tc := range []struct {
+		art  *id3Picture
+		code int
+	}{
+		{&id3Picture{mime: "image/png", kind: 3, data: png}, http.StatusOK},
+		{&id3Picture{mime: "text/html", kind: 3, data: html}, http.StatusNotFound},
+		{nil, http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		artHandler{&mux{art: tc.art}}.ServeHTTP(w, httptest.NewRequest("GET", "/nowplaying/art", nil))
+		if w.Code != tc.code {
+			t.Errorf("art %+v: status %v, want %v", tc.art, w.Code, tc.code)
+		}
+		if w.Code == http.StatusOK && (w.Header().Get("Content-Type") != "image/png" || w.Header().Get("X-Content-Type-Options") != "nosniff") {
+			t.Errorf("art headers %v", w.Header())
+		}
+	}
+}
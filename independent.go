@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// independentHandler streams a shuffle of its own to each connection, see -mode independent.
+// Every connection has a mux of its own: path is walked, shuffled, read and decoded
+// per connection, so CPU use, disk reads and memory grow with the number of listeners.
+// The blacklist of the broadcast is shared, recently played files are per connection.
+type independentHandler struct {
+	*mux // the live broadcast, its path is played
+
+	record bool
+	conns  *int64 // connections served
+}
+
+func (ih independentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.AddInt64(ih.conns, 1) > int64(*maxConnections) {
+		atomic.AddInt64(ih.conns, -1)
+		log.Printf("Error: new connection request denied, already serving %v connections. See -h for details.", *maxConnections)
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	defer atomic.AddInt64(ih.conns, -1)
+
+	m := new(mux)
+	m.playlists = ih.playlists
+	m.blacklist = ih.blacklist
+	m.history = loadHistory("", recentlyPlayed)
+	m.start(ih.path)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ih.stopping: // shutting down
+		}
+		m.stop()
+	}()
+
+	streamHandler{mux: m, record: ih.record}.ServeHTTP(w, r)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileIndex remembers files found by earlier scans of the path, so on rescan
+// only new files and files with a changed size or modification time are
+// evaluated again. Entries not seen during a whole scan are removed.
+type fileIndex struct {
+	sync.Mutex
+
+	entries map[string]*indexEntry
+	gen     int         // current scan generation
+	probes  *probeCache // of playable files, see -dedupe
+}
+
+type indexEntry struct {
+	modTime  time.Time
+	size     int64
+	playable bool
+	gen      int           // scan generation the file was last seen in
+	duration time.Duration // of playable files, see /library
+	measured bool          // duration is known
+	loudness *loudness     // of playable files, nil: not scanned yet, see -loudnessscan
+}
+
+func newFileIndex() *fileIndex {
+	return &fileIndex{entries: make(map[string]*indexEntry), probes: newProbeCache(*probeCacheSize)}
+}
+
+// lookup reports whether the file at path is playable. The result of an earlier
+// evaluation is reused if the file's size and modification time are unchanged.
+func (idx *fileIndex) lookup(path string, info os.FileInfo) bool {
+	idx.Lock()
+	defer idx.Unlock()
+
+	e, ok := idx.entries[path]
+	if !ok || !e.modTime.Equal(info.ModTime()) || e.size != info.Size() {
+		e = &indexEntry{
+			modTime:  info.ModTime(),
+			size:     info.Size(),
+			playable: playable(path, info),
+		}
+		fileCache.restore(path, e)
+		idx.entries[path] = e
+	}
+	e.gen = idx.gen
+
+	return e.playable
+}
+
+// unplayable marks path not playable until it changes, e.g. its content isn't in -codec format.
+func (idx *fileIndex) unplayable(path string) {
+	idx.Lock()
+	defer idx.Unlock()
+	if e, ok := idx.entries[path]; ok {
+		e.playable = false
+	}
+}
+
+// has reports whether path was found playable by the last scans.
+func (idx *fileIndex) has(path string) bool {
+	idx.Lock()
+	defer idx.Unlock()
+	e, ok := idx.entries[path]
+	return ok && e.playable
+}
+
+// duration returns the duration of the file at path if it's measured, see /library.
+func (idx *fileIndex) duration(path string) (time.Duration, bool) {
+	idx.Lock()
+	defer idx.Unlock()
+	e, ok := idx.entries[path]
+	if !ok || !e.measured {
+		return 0, false
+	}
+	return e.duration, true
+}
+
+// list returns the playable files found by the last scans, sorted.
+func (idx *fileIndex) list() []string {
+	idx.Lock()
+	var files []string
+	for path, e := range idx.entries {
+		if e.playable {
+			files = append(files, path)
+		}
+	}
+	idx.Unlock()
+	sort.Strings(files)
+	return files
+}
+
+// sweep removes files not looked up since the previous sweep and starts a new scan generation.
+func (idx *fileIndex) sweep() {
+	idx.Lock()
+	for path, e := range idx.entries {
+		if e.gen != idx.gen {
+			delete(idx.entries, path)
+			idx.probes.remove(path)
+		}
+	}
+	idx.gen++
+	idx.Unlock()
+}
+
+// playable evaluates whether the file at path should be broadcast.
+func playable(path string, info os.FileInfo) bool {
+	// probably audio file in -codec format, compressed files are decompressed when opened
+	name := strings.TrimSuffix(strings.ToLower(info.Name()), ".gz")
+	if !strings.HasSuffix(name, "."+*codec) {
+		return false
+	}
+	if *maxFileSize > 0 && info.Size() > *maxFileSize<<20 {
+		if *verbose {
+			fmt.Fprintf(infoOut, "Skipping %v, larger than %vMB\n", path, *maxFileSize)
+		}
+		return false
+	}
+	return true
+}
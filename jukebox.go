@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// listHandler lists the files available from /play/, one per line.
+type listHandler struct {
+	*mux
+}
+
+func (lh listHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, p := range lh.index.list() {
+		if strings.HasSuffix(strings.ToLower(p), ".gz") {
+			continue // not seekable
+		}
+		if rel, err := filepath.Rel(lh.path, p); err == nil {
+			fmt.Fprintln(w, filepath.ToSlash(rel))
+		}
+	}
+}
+
+// playHandler serves a single file of the library on demand, independent of
+// the broadcast, e.g. /play/jazz/track.mp3. Range requests are supported.
+// Connections are not counted against -max.
+type playHandler struct {
+	*mux
+}
+
+func (ph playHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rel := strings.TrimPrefix(r.URL.Path, "/play/")
+	p := filepath.Join(ph.path, filepath.FromSlash(rel))
+	if rel, err := filepath.Rel(ph.path, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		http.NotFound(w, r) // outside of path
+		return
+	}
+	if !ph.index.has(p) || ph.blacklist.has(p) || strings.HasSuffix(strings.ToLower(p), ".gz") {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	if *codec == "wav" {
+		w.Header().Set("Content-Type", "audio/wav")
+	} else {
+		w.Header().Set("Content-Type", "audio/mpeg")
+	}
+	http.ServeContent(egressWriter{w}, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fgergo/mp3"
+)
+
+// library summarizes the library on /library.
+type library struct {
+	Files         int    `json:"files"`
+	TotalDuration string `json:"totalDuration"`             // "pending" until all files are measured
+	LoudnessFiles int    `json:"loudnessScanned,omitempty"` // files scanned with -loudnessscan so far, see /library?loudness=1
+}
+
+// libraryHandler serves the number of playable files and their total duration as JSON.
+// Measuring durations reads whole files, it's started by the first request and runs
+// in the background, one file at a time. The file count is available immediately.
+// With -loudnessscan /library?loudness=1 lists the loudness of the files scanned so far.
+type libraryHandler struct {
+	*mux
+
+	once *sync.Once
+}
+
+func (lh libraryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lh.once.Do(func() { go lh.index.measure() })
+	if *loudnessScan && r.FormValue("loudness") != "" {
+		lh.serveLoudness(w)
+		return
+	}
+
+	files, total, pending, scanned := 0, time.Duration(0), false, 0
+	lh.index.Lock()
+	for path, e := range lh.index.entries {
+		if !e.playable || lh.blacklist.has(path) {
+			continue
+		}
+		files++
+		total += e.duration
+		pending = pending || !e.measured
+		if e.loudness != nil {
+			scanned++
+		}
+	}
+	lh.index.Unlock()
+	lib := library{Files: files, TotalDuration: "pending", LoudnessFiles: scanned}
+	if !pending {
+		lib.TotalDuration = total.Round(time.Second).String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(lib)
+}
+
+// measure sets the duration of playable files not measured yet, then checks for new files every 10s.
+func (idx *fileIndex) measure() {
+	for {
+		idx.Lock()
+		var todo []string
+		for path, e := range idx.entries {
+			if e.playable && !e.measured {
+				todo = append(todo, path)
+			}
+		}
+		idx.Unlock()
+
+		for _, path := range todo {
+			workers.acquire()
+			d := fileDuration(path) // file is read without holding the lock
+			workers.release()
+			idx.Lock()
+			if e, ok := idx.entries[path]; ok {
+				e.duration, e.measured = d, true
+				fileCache.putEntry(path, e)
+			}
+			idx.Unlock()
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// fileDuration returns the duration of audio in the file at path, 0 if it can't be read.
+func fileDuration(path string) time.Duration {
+	t, err := openTrack(path, nil)
+	if err != nil {
+		return 0
+	}
+	defer t.c.Close()
+
+	if *codec == "wav" {
+		f, n, err := readWAVHeader(t.r)
+		if err != nil || n < 0 {
+			return 0
+		}
+		return time.Duration(n) * time.Second / time.Duration(f.sampleRate*f.blockAlign())
+	}
+	var d time.Duration
+	var f mp3.Frame
+	skipped := 0
+	r := &readErrorReader{r: t.r}
+	dec := mp3.NewDecoder(r)
+	for {
+		err := dec.Decode(&f, &skipped)
+		if err == nil {
+			fd := f.Duration()
+			if fd <= 0 || fd > maxFrameDuration {
+				fd = *fallbackDur
+			}
+			d += fd
+			continue
+		}
+		if r.err != nil { // end of file or read error, e.g. EIO, frame errors are skipped
+			return d
+		}
+	}
+}
+
+// readErrorReader remembers the last error reading r, telling read errors apart from
+// errors of frames found by the decoder.
+type readErrorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (er *readErrorReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err != nil {
+		er.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// listen returns n listeners on addr. With n > 1 the listening sockets share addr
+// using SO_REUSEPORT, the kernel distributes new connections among them,
+// so accepts run in parallel on busy servers. See reusePort for platform support.
+//
+// TCP keepalive is enabled on accepted connections with period keepAlive, 0 disables it.
+// The OS detects dead peers and their streams end, even if no frames are sent to them.
+// Go sets both the idle time before the first probe and the interval between probes
+// to keepAlive (linux, BSDs, macOS, windows), the number of probes is the OS default
+// (9 on linux): a dead peer is detected after about 10*keepAlive.
+func listen(addr string, n int, keepAlive time.Duration) ([]net.Listener, error) {
+	if n < 1 {
+		n = 1
+	}
+	if keepAlive == 0 {
+		keepAlive = -1 // disabled
+	}
+	lc := net.ListenConfig{KeepAlive: keepAlive}
+	if n > 1 {
+		lc.Control = reusePort
+	}
+
+	ls := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, err
+		}
+		ls = append(ls, l)
+	}
+
+	return ls, nil
+}
+
+// systemdListeners returns the listening sockets passed by systemd socket activation,
+// nil if there are none. The environment contract (sd_listen_fds(3)):
+//
+//	LISTEN_PID   pid of the process the sockets are meant for, must be ours
+//	LISTEN_FDS   number of sockets passed, as file descriptors 3, 4, ...
+//
+// Both variables are unset, so child processes (e.g. ffmpeg) don't see them.
+// TCP keepalive is set on accepted connections as by listen.
+func systemdListeners(keepAlive time.Duration) ([]net.Listener, error) {
+	pid, n := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if pid == "" || n == "" {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	nfds, err := strconv.Atoi(n)
+	if err != nil || nfds < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %#v", n)
+	}
+
+	ls := make([]net.Listener, 0, nfds)
+	for fd := 3; fd < 3+nfds; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close() // l has its own copy
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, fmt.Errorf("systemd socket %v: %v", fd, err)
+		}
+		ls = append(ls, keepAliveListener{l, keepAlive})
+	}
+	return ls, nil
+}
+
+// keepAliveListener sets TCP keepalive on accepted connections with period keepAlive, 0 disables it.
+type keepAliveListener struct {
+	net.Listener
+	keepAlive time.Duration
+}
+
+func (l keepAliveListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.SetKeepAlive(l.keepAlive > 0)
+		if l.keepAlive > 0 {
+			tc.SetKeepAlivePeriod(l.keepAlive)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Messages are written to these, -logjson replaces them with JSON writers.
+// Standard output is never used for JSON, audio may be written there.
+var (
+	infoOut io.Writer = os.Stdout // verbose messages, see -v
+	errOut  io.Writer = os.Stderr // errors
+	logOut  io.Writer = os.Stderr // log package, debug messages and mp3 decoder output
+)
+
+// verbosity is the -v level:
+//
+//	0: errors only
+//	1: per-track messages, e.g. now playing, connections (-v, -v=true)
+//	2: debug messages, including the mp3 decoder's (also the hidden -debug argument)
+//	3: a debug message per frame broadcast too
+type verbosity int
+
+// levelFlag defines a verbosity flag, given without value it's level 1.
+func levelFlag(name, usage string) *verbosity {
+	v := new(verbosity)
+	flag.Var(v, name, usage)
+	return v
+}
+
+func (v *verbosity) String() string {
+	if v == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*v))
+}
+
+func (v *verbosity) Set(s string) error {
+	if b, err := strconv.ParseBool(s); err == nil {
+		*v = 0
+		if b {
+			*v = 1
+		}
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return errors.New("use a level (0-3), true or false")
+	}
+	*v = verbosity(n)
+	return nil
+}
+
+func (v *verbosity) IsBoolFlag() bool { return true }
+
+// jsonLog writes each message as a single line JSON object to standard error.
+type jsonLog struct {
+	level string
+}
+
+type jsonLogEntry struct {
+	Level string    `json:"level"`
+	Time  time.Time `json:"time"`
+	Msg   string    `json:"msg"`
+	QID   *int      `json:"qid,omitempty"`
+}
+
+var (
+	jsonLogMu sync.Mutex // one line at a time from all levels
+	qidRe     = regexp.MustCompile(`qid: (\d+)`)
+)
+
+func (jl jsonLog) Write(p []byte) (int, error) {
+	e := jsonLogEntry{Level: jl.level, Time: time.Now(), Msg: strings.TrimRight(string(p), "\n")}
+	if strings.HasPrefix(e.Msg, "Error") {
+		e.Level = "error"
+	}
+	if m := qidRe.FindStringSubmatch(e.Msg); m != nil {
+		if qid, err := strconv.Atoi(m[1]); err == nil {
+			e.QID = &qid
+		}
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	jsonLogMu.Lock()
+	defer jsonLogMu.Unlock()
+	if _, err := os.Stderr.Write(append(b, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// setJSONLog makes all messages single line JSON objects on standard error.
+func setJSONLog() {
+	infoOut = jsonLog{"info"}
+	errOut = jsonLog{"error"}
+	logOut = jsonLog{"debug"}
+	log.SetFlags(0) // time is a JSON field
+	log.SetOutput(logOut)
+}
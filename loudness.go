@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// loudness is a simple loudness estimate of a file, see -loudnessscan. Not ReplayGain
+// or EBU R128, no weighting or gating, still it's good for finding outliers.
+type loudness struct {
+	RMS  float64 // dBFS, average of the whole file
+	Peak float64 // dBFS, of the highest sample
+}
+
+// decodeError means the file's content can't be decoded, measuring it again is pointless
+// until it changes. Other errors of fileLoudness (e.g. ffmpeg not found or killed) are transient.
+type decodeError struct {
+	err error
+}
+
+func (de decodeError) Error() string {
+	return de.err.Error()
+}
+
+// fileLoudness decodes the file at path and measures its loudness. mp3 files are
+// decoded by ffmpeg (see -ffmpeg) downmixed to mono, wav files are read as is.
+func fileLoudness(path string) (loudness, error) {
+	t, err := openTrack(path, nil)
+	if err != nil {
+		return loudness{}, err
+	}
+	defer t.c.Close()
+
+	if *codec == "wav" {
+		if _, _, err := readWAVHeader(t.r); err != nil {
+			return loudness{}, decodeError{err}
+		}
+		return measureLoudness(t.r)
+	}
+	cmd := exec.Command(*ffmpegPath, "-hide_banner", "-loglevel", "error", "-f", "mp3", "-i", "pipe:0", "-vn", "-ac", "1", "-ar", "22050", "-f", "s16le", "pipe:1")
+	cmd.Stdin = t.r
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return loudness{}, err
+	}
+	if err := cmd.Start(); err != nil {
+		return loudness{}, err
+	}
+	l, err := measureLoudness(out)
+	werr := cmd.Wait()
+	var ee *exec.ExitError
+	if errors.As(werr, &ee) && ee.Exited() { // ffmpeg failed decoding, not killed
+		werr = decodeError{werr}
+	}
+	if err == nil {
+		err = werr
+	}
+	return l, err
+}
+
+// measureLoudness reads 16 bit little endian samples from r until its end.
+func measureLoudness(r io.Reader) (loudness, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var sum float64
+	var n, peak int
+	var s [2]byte
+	for {
+		if _, err := io.ReadFull(br, s[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return loudness{}, err
+		}
+		v := int(int16(binary.LittleEndian.Uint16(s[:])))
+		sum += float64(v * v)
+		if v < 0 {
+			v = -v
+		}
+		if v > peak {
+			peak = v
+		}
+		n++
+	}
+	if n == 0 {
+		return loudness{RMS: dBFS(0), Peak: dBFS(0)}, nil
+	}
+	return loudness{RMS: dBFS(math.Sqrt(sum / float64(n))), Peak: dBFS(float64(peak))}, nil
+}
+
+// dBFS returns the level of 16 bit sample value v in decibels relative to full scale.
+// Silence is -90.3dBFS, the level of the smallest 16 bit sample.
+func dBFS(v float64) float64 {
+	if v < 1 {
+		v = 1
+	}
+	return 20 * math.Log10(v/32768)
+}
+
+// scanLoudness measures the loudness of playable files not scanned yet, one at a time,
+// then checks for new and changed files every 10s. After each file it sleeps as long
+// as measuring took, so the scan uses at most about half of a CPU.
+func (idx *fileIndex) scanLoudness() {
+	for {
+		idx.Lock()
+		var todo []string
+		for path, e := range idx.entries {
+			if e.playable && e.loudness == nil {
+				todo = append(todo, path)
+			}
+		}
+		idx.Unlock()
+
+		retry := 10 * time.Second
+	scan:
+		for _, path := range todo {
+			workers.acquire()
+			t0 := time.Now()
+			l, err := fileLoudness(path) // file is read without holding the lock
+			workers.release()
+			var de decodeError
+			switch {
+			case errors.As(err, &de):
+				if *verbose {
+					fmt.Fprintf(infoOut, "Can't measure loudness of %v: %v\n", path, err)
+				}
+				l = loudness{math.NaN(), math.NaN()} // not measurable, not retried until the file changes
+			case err != nil:
+				log.Printf("Error: measuring loudness of %v failed, retrying in a minute: %v", path, err)
+				retry = time.Minute
+				break scan
+			}
+			idx.Lock()
+			if e, ok := idx.entries[path]; ok {
+				e.loudness = &l
+				fileCache.putEntry(path, e)
+			}
+			idx.Unlock()
+			time.Sleep(time.Since(t0))
+		}
+		time.Sleep(retry)
+	}
+}
+
+// fileLevel is a file's loudness on /library?loudness=1.
+type fileLevel struct {
+	Path string  `json:"path"` // relative to path
+	RMS  float64 `json:"rmsDB"`
+	Peak float64 `json:"peakDB"`
+}
+
+// round1 rounds v to 1 decimal, without negative zero.
+func round1(v float64) float64 {
+	return math.Round(v*10)/10 + 0
+}
+
+// serveLoudness lists the loudness of files scanned so far, loudest first, the scan may be in progress.
+func (lh libraryHandler) serveLoudness(w http.ResponseWriter) {
+	levels := []fileLevel{}
+	lh.index.Lock()
+	for path, e := range lh.index.entries {
+		l := e.loudness
+		if !e.playable || l == nil || math.IsNaN(l.RMS) {
+			continue
+		}
+		rel, err := filepath.Rel(lh.path, path)
+		if err != nil {
+			rel = path
+		}
+		levels = append(levels, fileLevel{filepath.ToSlash(rel), round1(l.RMS), round1(l.Peak)})
+	}
+	lh.index.Unlock()
+	sort.Slice(levels, func(i, j int) bool { return levels[i].RMS > levels[j].RMS })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(levels)
+}
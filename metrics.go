@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// histogram counts durations in cumulative buckets, Prometheus style. Adding is lock free.
+type histogram struct {
+	bounds []time.Duration // upper bounds of buckets, ascending
+	counts []int64         // per bucket, not cumulative, last is +Inf, accessed atomically
+	sum    int64           // ns, accessed atomically
+}
+
+func newHistogram(bounds ...time.Duration) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *histogram) add(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// write writes h in Prometheus text format as metric name, in seconds.
+func (h *histogram) write(w http.ResponseWriter, name, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v histogram\n", name, help, name)
+	var n int64
+	for i := range h.counts {
+		n += atomic.LoadInt64(&h.counts[i])
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i].Seconds(), 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%v_bucket{le=\"%v\"} %v\n", name, le, n)
+	}
+	fmt.Fprintf(w, "%v_sum %v\n%v_count %v\n", name, time.Duration(atomic.LoadInt64(&h.sum)).Seconds(), name, n)
+}
+
+// sendLatency is the time it takes to write a frame to a client, see metricsHandler.
+// Buckets: 1ms, 5ms, 10ms, 50ms, 100ms, 500ms, 1s, 5s, 10s, 44s (slow clients are
+// disconnected after 44s). Sends normally take well under a millisecond, longer ones
+// mean the client's connection (or the server's) can't keep up.
+var sendLatency = newHistogram(
+	time.Millisecond, 5*time.Millisecond, 10*time.Millisecond, 50*time.Millisecond, 100*time.Millisecond,
+	500*time.Millisecond, time.Second, 5*time.Second, 10*time.Second, 44*time.Second)
+
+// metricsHandler serves metrics in Prometheus text format on /metrics.
+type metricsHandler struct {
+	*mux
+}
+
+func (mh metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mh.Lock()
+	conns := len(mh.clients)
+	mh.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "# HELP boringstreamer_connections Streaming connections of the broadcast.\n# TYPE boringstreamer_connections gauge\nboringstreamer_connections %v\n", conns)
+	if d := atomic.LoadInt64(&firstFrame); d > 0 {
+		fmt.Fprintf(w, "# HELP boringstreamer_time_to_first_frame_seconds Time from start to the first frame sent to a listener.\n# TYPE boringstreamer_time_to_first_frame_seconds gauge\nboringstreamer_time_to_first_frame_seconds %v\n", time.Duration(d).Seconds())
+	}
+	sendLatency.write(w, "boringstreamer_send_seconds", "Time to write a frame to a client.")
+}
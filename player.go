@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+)
+
+// rootHandler serves / only, other unknown paths are not found. With -raw the stream is
+// served, otherwise an HTML player of /stream.
+type rootHandler struct {
+	stream http.Handler
+}
+
+func (rh rootHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if *raw {
+		rh.stream.ServeHTTP(w, r)
+		return
+	}
+
+	title := *icyName
+	if title == "" {
+		title = "BoringStreamer"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>%[1]v</title></head>
+<body>
+<h1>%[1]v</h1>
+<audio controls autoplay src="/stream"></audio>
+</body>
+</html>
+`, html.EscapeString(title))
+}
+
+// faviconHandler answers browsers' /favicon.ico requests with no content, cached for a day.
+type faviconHandler struct{}
+
+func (faviconHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=86400")
+	w.WriteHeader(http.StatusNoContent)
+}
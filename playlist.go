@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// streamURL returns the URL of path on this server as seen by the client of r.
+// X-Forwarded-Proto and X-Forwarded-Host of a trusted reverse proxy are used, see -trustproxy.
+func streamURL(r *http.Request, path string) string {
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if fromProxy(r) {
+		if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+			scheme = p
+		}
+		if h := r.Header.Get("X-Forwarded-Host"); h != "" {
+			host = h
+		}
+	}
+	return scheme + "://" + host + path
+}
+
+// playlistHandler serves a playlist of the stream for players subscribing
+// to playlists, /stream.pls or /stream.m3u.
+type playlistHandler struct {
+	m3u bool
+}
+
+func (ph playlistHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	title := *icyName
+	if title == "" {
+		title = "BoringStreamer"
+	}
+	u := streamURL(r, "/")
+	if !*raw {
+		u = streamURL(r, "/stream")
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if ph.m3u {
+		w.Header().Set("Content-Type", "audio/x-mpegurl")
+		fmt.Fprintf(w, "#EXTM3U\n#EXTINF:-1,%v\n%v\n", title, u)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/x-scpls")
+	fmt.Fprintf(w, "[playlist]\nNumberOfEntries=1\nFile1=%v\nTitle1=%v\nLength1=-1\nVersion=2\n", u, title)
+}
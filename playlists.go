@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// playlists is a directory of .m3u or .m3u8 playlists, see -playlists. Files of the
+// selected playlist are broadcast instead of files found under path. Playlists are
+// named by file name without extension, e.g. chill for chill.m3u.
+type playlists struct {
+	sync.Mutex
+
+	dir      string
+	selected string        // name of playlist being played
+	changed  chan struct{} // closed and replaced when another playlist is selected
+}
+
+// loadPlaylists returns the playlists in dir, the first one alphabetically is selected.
+func loadPlaylists(dir string) (*playlists, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	pl := &playlists{dir: dir, changed: make(chan struct{})}
+	names, err := pl.names()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no .m3u or .m3u8 playlists in " + dir)
+	}
+	pl.selected = names[0]
+	return pl, nil
+}
+
+// scan returns the file names of playlists in pl's directory by playlist name,
+// extensions in any case. Of playlists with the same name the first file alphabetically is used.
+func (pl *playlists) scan() (map[string]string, error) {
+	infos, err := ioutil.ReadDir(pl.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, info := range infos {
+		ext := strings.ToLower(filepath.Ext(info.Name()))
+		if info.Mode().IsRegular() && (ext == ".m3u" || ext == ".m3u8") {
+			name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+			if _, ok := files[name]; !ok {
+				files[name] = info.Name()
+			}
+		}
+	}
+	return files, nil
+}
+
+// names returns the names of playlists in pl's directory, sorted.
+func (pl *playlists) names() ([]string, error) {
+	files, err := pl.scan()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// current returns the name of the selected playlist and a channel closed when another one is selected.
+// Nil pl has no playlist, the channel is never closed.
+func (pl *playlists) current() (string, <-chan struct{}) {
+	if pl == nil {
+		return "", nil
+	}
+	pl.Lock()
+	defer pl.Unlock()
+	return pl.selected, pl.changed
+}
+
+// choose selects the playlist called name, the one after the selected one if name is empty.
+// Returns the name of the selected playlist.
+func (pl *playlists) choose(name string) (string, error) {
+	names, err := pl.names()
+	if err != nil {
+		return "", err
+	}
+	pl.Lock()
+	defer pl.Unlock()
+	if name == "" && len(names) > 0 {
+		name = names[0]
+		for i, n := range names {
+			if n == pl.selected && i+1 < len(names) {
+				name = names[i+1]
+			}
+		}
+	}
+	i := sort.SearchStrings(names, name)
+	if i == len(names) || names[i] != name {
+		return "", fmt.Errorf("no such playlist: %v", name)
+	}
+	if name != pl.selected {
+		pl.selected = name
+		close(pl.changed)
+		pl.changed = make(chan struct{})
+	}
+	return name, nil
+}
+
+// files returns the absolute paths of files in the selected playlist, relative
+// paths are relative to the playlist's directory. URLs are ignored.
+func (pl *playlists) files() ([]string, error) {
+	pl.Lock()
+	name := pl.selected
+	pl.Unlock()
+	playlistFiles, err := pl.scan()
+	if err != nil {
+		return nil, err
+	}
+	f, ok := playlistFiles[name]
+	if !ok {
+		return nil, fmt.Errorf("no such playlist: %v", name)
+	}
+	lines, err := readLines(filepath.Join(pl.dir, f))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, l := range lines {
+		l = strings.TrimSpace(strings.TrimPrefix(l, "\ufeff"))
+		if l == "" || strings.HasPrefix(l, "#") || strings.Contains(l, "://") {
+			continue
+		}
+		files = append(files, absPath(pl.dir, filepath.FromSlash(l)))
+	}
+	return files, nil
+}
+
+// playlistSelectHandler selects (POST) the playlist given in form value name,
+// the next one if name is not given. The current track finishes first.
+type playlistSelectHandler struct {
+	*mux
+}
+
+func (ph playlistSelectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ph.playlists == nil {
+		http.Error(w, "no playlists, see -playlists", http.StatusNotFound)
+		return
+	}
+
+	name, err := ph.playlists.choose(r.FormValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if *verbose {
+		fmt.Fprintf(infoOut, "Playlist selected: %v\n", name)
+	}
+	fmt.Fprintf(w, "selected playlist: %v, plays after current track\n", name)
+}
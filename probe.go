@@ -0,0 +1,159 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fgergo/mp3"
+)
+
+// probe is learned by reading the start of a file.
+type probe struct {
+	artist, title, genre string // from ID3v2 tag
+	kbps                 int    // bitrate of first frame
+}
+
+// probeFile reads the ID3v2 tag and the first frame of the file at path.
+func probeFile(path string) probe {
+	var p probe
+	t, err := openTrack(path, nil)
+	if err != nil {
+		return p
+	}
+	defer t.c.Close()
+	if t.tag != nil {
+		p.artist, p.title, p.genre = t.tag.artist, t.tag.title, t.tag.genre
+	}
+
+	if *codec == "wav" {
+		f, _, err := readWAVHeader(t.r)
+		if err == nil {
+			p.kbps = f.sampleRate * f.channels * 16 / 1000
+		}
+		return p
+	}
+	var f mp3.Frame
+	skipped := 0
+	log.SetOutput(ioutil.Discard) // silence mp3 debug/log output
+	err = mp3.NewDecoder(t.r).Decode(&f, &skipped)
+	log.SetOutput(logOut)
+	if err == nil {
+		p.kbps = int(f.Header().BitRate()) / 1000
+	}
+	return p
+}
+
+// probeCache keeps probes of files so they are read only once, a probe is
+// renewed if the file's modification time changes. At most max probes are
+// kept (0: unlimited), the least recently used is dropped first.
+// E.g. -dedupe and tag filters use probes.
+type probeCache struct {
+	sync.Mutex
+
+	max     int
+	entries map[string]*list.Element // of *probeEntry
+	lru     *list.List               // most recently used first
+}
+
+type probeEntry struct {
+	path    string
+	modTime time.Time
+	probe
+}
+
+func newProbeCache(max int) *probeCache {
+	return &probeCache{max: max, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// get returns the probe of the file at path with modification time modTime.
+func (pc *probeCache) get(path string, modTime time.Time) probe {
+	pc.Lock()
+	if el, ok := pc.entries[path]; ok {
+		pe := el.Value.(*probeEntry)
+		if pe.modTime.Equal(modTime) {
+			pc.lru.MoveToFront(el)
+			pc.Unlock()
+			return pe.probe
+		}
+		pc.lru.Remove(el)
+		delete(pc.entries, path)
+	}
+	pc.Unlock()
+
+	pe := &probeEntry{path: path, modTime: modTime}
+	if p, ok := fileCache.probe(path, modTime); ok {
+		pe.probe = p
+	} else {
+		pe.probe = probeFile(path) // file is read without holding the lock
+		fileCache.putProbe(path, modTime, pe.probe)
+	}
+
+	pc.Lock()
+	if el, ok := pc.entries[path]; ok { // probed meanwhile
+		pc.lru.Remove(el)
+	}
+	pc.entries[path] = pc.lru.PushFront(pe)
+	if pc.max > 0 && pc.lru.Len() > pc.max {
+		oldest := pc.lru.Back()
+		pc.lru.Remove(oldest)
+		delete(pc.entries, oldest.Value.(*probeEntry).path)
+	}
+	pc.Unlock()
+	return pe.probe
+}
+
+// cached returns the probe of the file at path if it was read already, the file isn't read.
+func (pc *probeCache) cached(path string) (probe, bool) {
+	pc.Lock()
+	defer pc.Unlock()
+	if el, ok := pc.entries[path]; ok {
+		return el.Value.(*probeEntry).probe, true
+	}
+	return probe{}, false
+}
+
+// remove drops the probe of the file at path.
+func (pc *probeCache) remove(path string) {
+	pc.Lock()
+	if el, ok := pc.entries[path]; ok {
+		pc.lru.Remove(el)
+		delete(pc.entries, path)
+	}
+	pc.Unlock()
+}
+
+// findAudio returns the first file under path with a decodable audio frame,
+// error if none is found within timeout.
+func findAudio(path string, timeout time.Duration) (string, error) {
+	errFound := errors.New("found")
+	found := make(chan string, 1)
+	go func() {
+		filepath.Walk(path, func(wpath string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() || !playable(wpath, info) {
+				return nil
+			}
+			if probeFile(wpath).kbps > 0 {
+				found <- wpath
+				return errFound
+			}
+			return nil
+		})
+		close(found)
+	}()
+
+	select {
+	case f, ok := <-found:
+		if !ok {
+			return "", errors.New("no decodable " + *codec + " file")
+		}
+		return f, nil
+	case <-time.After(timeout):
+		return "", errors.New("none found in " + timeout.String())
+	}
+}
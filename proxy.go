@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies are the reverse proxies whose X-Forwarded-* and X-Real-IP headers are trusted, see -trustproxy.
+var trustedProxies []*net.IPNet
+
+// parseCIDRs parses a comma separated list of CIDRs or IP addresses, e.g. "10.0.0.0/8,::1".
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	if s == "" {
+		return nets, nil
+	}
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %#v", e)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trustedProxy reports whether ip is the address of a trusted reverse proxy.
+func trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromProxy reports whether r was received from a trusted reverse proxy.
+func fromProxy(r *http.Request) bool {
+	return trustedProxy(remoteIP(r.RemoteAddr))
+}
+
+// remoteIP returns the IP address of remoteAddr (host:port).
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// clientIP returns the IP address of the client of r. If r is from a trusted proxy,
+// X-Forwarded-For is followed from the last hop back while the hops are trusted
+// proxies too, the first untrusted address is the client. Earlier addresses are set
+// by the client and can't be trusted. Without X-Forwarded-For, X-Real-IP is used.
+func clientIP(r *http.Request) string {
+	ip := remoteIP(r.RemoteAddr)
+	if !trustedProxy(ip) {
+		return ip
+	}
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	if len(hops) == 0 {
+		if rip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(rip) != nil {
+			return rip
+		}
+		return ip
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			return ip // malformed, last address known good
+		}
+		ip = hop
+		if !trustedProxy(hop) {
+			return ip
+		}
+	}
+	return ip
+}
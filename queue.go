@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// queueHandler serves /queue.m3u, a snapshot of the broadcast as a playlist: recently
+// played files and the one being broadcast, then the files about to be played in order,
+// requested files (see -requests), the one opened ahead and the rest of the shuffle pass.
+// It's a snapshot only: files found after the pass started aren't in it, the next pass is
+// shuffled anew, and requests, /replay, /interrupt, station IDs and skips change it.
+// Durations are known once measured (see /library), titles once tags were read (e.g. -dedupe).
+// Paths are relative to path, served at the root of path the playlist plays from there,
+// with ?absolute=1 paths are absolute.
+type queueHandler struct {
+	*mux
+}
+
+func (qh queueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	qh.Lock()
+	ahead, upcoming := qh.ahead, qh.upcoming
+	qh.Unlock()
+
+	files := qh.history.list() // ends with the track being broadcast, or opened ahead
+	if n := len(files); n > 0 && files[n-1] == ahead {
+		files = files[:n-1]
+	}
+	files = append(files, qh.requests.list()...)
+	if ahead != "" {
+		files = append(files, ahead)
+	}
+	files = append(files, upcoming...)
+
+	absolute := r.FormValue("absolute") != ""
+	w.Header().Set("Content-Type", "audio/x-mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, "#EXTM3U\n")
+	for _, f := range files {
+		secs := -1
+		if d, ok := qh.index.duration(f); ok {
+			secs = int(d.Seconds() + 0.5)
+		}
+		title := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+		if p, ok := qh.index.probes.cached(f); ok && p.title != "" {
+			title = p.title
+			if p.artist != "" {
+				title = p.artist + " - " + p.title
+			}
+		}
+		if rel, err := filepath.Rel(qh.path, f); err == nil && !absolute && !strings.HasPrefix(rel, "..") {
+			f = filepath.ToSlash(rel)
+		}
+		fmt.Fprintf(w, "#EXTINF:%v,%v\n%v\n", secs, title, f)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// requestQueue holds files listeners requested, played in order ahead of the shuffle, see -requests.
+type requestQueue struct {
+	sync.Mutex
+
+	files    []string
+	max      int                  // queue length
+	interval time.Duration        // minimum time between requests of a client, 0: no limit
+	last     map[string]time.Time // time of last request by client IP
+}
+
+var (
+	errRequested   = errors.New("already requested")
+	errQueueFull   = errors.New("request queue is full")
+	errRateLimited = errors.New("too many requests, try later")
+)
+
+func newRequestQueue(max int, interval time.Duration) *requestQueue {
+	return &requestQueue{max: max, interval: interval, last: make(map[string]time.Time)}
+}
+
+// add queues file requested by client ip.
+func (rq *requestQueue) add(file, ip string) error {
+	rq.Lock()
+	defer rq.Unlock()
+	now := time.Now()
+	for c, t := range rq.last {
+		if now.Sub(t) >= rq.interval {
+			delete(rq.last, c)
+		}
+	}
+	if _, ok := rq.last[ip]; ok && rq.interval > 0 {
+		return errRateLimited
+	}
+	for _, f := range rq.files {
+		if f == file {
+			return errRequested
+		}
+	}
+	if len(rq.files) >= rq.max {
+		return errQueueFull
+	}
+	rq.files = append(rq.files, file)
+	if rq.interval > 0 {
+		rq.last[ip] = now
+	}
+	return nil
+}
+
+// pop removes and returns the first requested file. Returns false if there's none, or rq is nil.
+func (rq *requestQueue) pop() (string, bool) {
+	if rq == nil {
+		return "", false
+	}
+	rq.Lock()
+	defer rq.Unlock()
+	if len(rq.files) == 0 {
+		return "", false
+	}
+	f := rq.files[0]
+	rq.files = rq.files[1:]
+	return f, true
+}
+
+// list returns the requested files in order, nil if rq is nil.
+func (rq *requestQueue) list() []string {
+	if rq == nil {
+		return nil
+	}
+	rq.Lock()
+	defer rq.Unlock()
+	return append([]string(nil), rq.files...)
+}
+
+// requestHandler queues the file given in form value path (POST), relative to path,
+// GET lists the queue. Files must be in the index and not blacklisted.
+type requestHandler struct {
+	*mux
+}
+
+func (rh requestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	switch r.Method {
+	case http.MethodGet:
+		for _, f := range rh.requests.list() {
+			fmt.Fprintln(w, f)
+		}
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := r.FormValue("path")
+	if p == "" {
+		http.Error(w, "missing path", http.StatusBadRequest)
+		return
+	}
+	p = absPath(rh.path, p)
+	info, err := os.Stat(p)
+	if err != nil || !info.Mode().IsRegular() || !rh.index.has(p) || rh.blacklist.has(p) {
+		http.Error(w, "not available: "+r.FormValue("path"), http.StatusNotFound)
+		return
+	}
+
+	switch err := rh.requests.add(p, clientIP(r)); err {
+	case nil:
+		fmt.Fprintf(w, "requested: %v\n", p)
+	case errRateLimited:
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		http.Error(w, fmt.Sprintf("%v: %v", err, p), http.StatusConflict)
+	}
+}
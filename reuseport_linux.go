@@ -0,0 +1,20 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+
+package main
+
+import "syscall"
+
+const soReusePort = 0xf // not defined by package syscall on every linux architecture
+
+// reusePort sets SO_REUSEPORT on the socket before bind.
+// Supported on linux 3.9+, macOS and the BSDs.
+func reusePort(network, address string, c syscall.RawConn) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && (!linux || mips || mipsle || mips64 || mips64le)
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePort is not supported on this platform, use -acceptors 1.
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT not supported on this platform, use -acceptors 1")
+}
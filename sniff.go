@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+)
+
+// sniff returns the audio format of r by its first bytes, after any ID3v2 tag: mp3, wav,
+// aac (ADTS), mp4, ogg or flac, "" if unknown. Nothing is consumed.
+func sniff(r *bufio.Reader) string {
+	b, _ := r.Peek(12)
+	switch {
+	case len(b) >= 12 && string(b[0:4]) == "RIFF" && string(b[8:12]) == "WAVE":
+		return "wav"
+	case len(b) >= 8 && string(b[4:8]) == "ftyp":
+		return "mp4"
+	case bytes.HasPrefix(b, []byte("OggS")):
+		return "ogg"
+	case bytes.HasPrefix(b, []byte("fLaC")):
+		return "flac"
+	case len(b) >= 2 && b[0] == 0xff && b[1]&0xf6 == 0xf0: // sync, layer 0
+		return "aac"
+	case len(b) >= 2 && b[0] == 0xff && b[1]&0xe0 == 0xe0 && b[1]&0x06 != 0: // sync, layer I-III
+		return "mp3"
+	}
+	return "" // e.g. junk before the first mp3 frame, the decoder searches for sync
+}
+
+// formatError is returned when opening a file of another format than -codec, e.g. aac named .mp3.
+type formatError struct {
+	found string
+}
+
+func (fe formatError) Error() string {
+	return fmt.Sprintf("content is %v, not %v (wrong extension?)", fe.found, *codec)
+}
+
+// checkFormat returns a formatError if r is recognized as another format than -codec.
+func checkFormat(r *bufio.Reader) error {
+	if f := sniff(r); f != "" && f != *codec {
+		return formatError{f}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// source opens the next connection of a live stream, blocking until the writer connects.
+type source func() (io.ReadCloser, error)
+
+// newSource returns the live stream source given by spec (see -source):
+//
+//	fifo:/path  named pipe, reopened when the writer closes it (unix-like systems only)
+//	unix:/path  unix domain socket created and listened on, one writer connection at a time
+func newSource(spec string) (source, error) {
+	switch {
+	case strings.HasPrefix(spec, "fifo:"):
+		path := strings.TrimPrefix(spec, "fifo:")
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode()&os.ModeNamedPipe == 0 {
+			return nil, errors.New(path + " is not a named pipe")
+		}
+		return func() (io.ReadCloser, error) {
+			return os.Open(path) // blocks until a writer opens the fifo
+		}, nil
+	case strings.HasPrefix(spec, "unix:"):
+		path := strings.TrimPrefix(spec, "unix:")
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if c, err := net.Dial("unix", path); err == nil {
+				c.Close()
+				return nil, errors.New(path + " is in use")
+			}
+			os.Remove(path) // left by an earlier run
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return func() (io.ReadCloser, error) {
+			return l.Accept()
+		}, nil
+	}
+	return nil, errors.New("unknown source, use fifo:/path or unix:/path")
+}
+
+// doneCloser closes done after closing the stream.
+type doneCloser struct {
+	io.Closer
+	done chan struct{}
+}
+
+func (dc doneCloser) Close() error {
+	err := dc.Closer.Close()
+	close(dc.done)
+	return err
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// trackSource tells which scheduling path produced the audio being broadcast.
+type trackSource string
+
+const (
+	fromLibrary   trackSource = "library"   // files under path, -playlist or -loopfile
+	fromStationID trackSource = "stationid" // -stationid announcement
+	fromInterrupt trackSource = "interrupt" // /interrupt announcement
+	fromRequest   trackSource = "request"   // requested on /request, see -requests
+	fromReplay    trackSource = "replay"    // /replay
+	fromLive      trackSource = "live"      // standard input or -source
+	fromScheduled trackSource = "scheduled" // -cron
+	fromSilence   trackSource = "silence"   // no track: holding (/stopafter), live source disconnected or nothing to play (-emptymount silence)
+)
+
+// nowPlaying describes the track being broadcast.
+type nowPlaying struct {
+	Path          string      `json:"path"` // "-" for standard input
+	Title         string      `json:"title,omitempty"`
+	Artist        string      `json:"artist,omitempty"`
+	Album         string      `json:"album,omitempty"`
+	Source        trackSource `json:"source"`
+	TagBytes      int64       `json:"tagBytes,omitempty"` // size of ID3v2 tag skipped before audio
+	Started       time.Time   `json:"started"`
+	SkippedFrames int         `json:"skippedFrames"`       // frames skipped due to decode errors in this track
+	CRCErrors     int         `json:"crcErrors,omitempty"` // frames skipped due to CRC errors in this track, see -maxcrcerrors
+	Gaps          int         `json:"gaps,omitempty"`      // discontinuities in this track: bytes skipped before a frame, see -reportgaps
+	GapBytes      int         `json:"gapBytes,omitempty"`  // bytes skipped in gaps
+}
+
+// status is served as JSON on /status.
+type status struct {
+	NowPlaying    nowPlaying      `json:"nowPlaying"`
+	State         string          `json:"state"`              // playing, stopping after current track or holding (see /stopafter, /resume)
+	Playlist      string          `json:"playlist,omitempty"` // selected playlist, see -playlists
+	Requests      []string        `json:"requests,omitempty"` // files queued by listeners, see -requests
+	Connections   int             `json:"connections"`
+	SkippedFrames int             `json:"skippedFrames"` // frames skipped due to decode errors since start
+	Gaps          int             `json:"gaps"`          // discontinuities since start, see -reportgaps
+	EmptyFiles    int             `json:"emptyFiles"`    // files without audio frames (empty, truncated) since start
+	Timing        timing          `json:"timing"`
+	Egress        egressStat      `json:"egress"`
+	Mounts        []mount         `json:"mounts"`
+	FrameSeq      int64           `json:"frameSeq,omitempty"`         // sequence number of last frame broadcast, see -debugframes
+	FirstFrame    string          `json:"timeToFirstFrame,omitempty"` // from start to the first frame sent to a listener, empty: none yet
+	NextScheduled *scheduledEvent `json:"nextScheduled,omitempty"`    // see -cron
+}
+
+// mount is the content state of a stream URL, see -emptymount.
+type mount struct {
+	Path  string `json:"path"`
+	State string `json:"state"` // playing, empty (nothing to play) or stopped
+}
+
+// egressStat is the traffic of all streams, see -maxegress.
+type egressStat struct {
+	BytesPerSec int64 `json:"bytesPerSec"` // in the last 10 seconds
+	MonthBytes  int64 `json:"monthBytes"`  // in this calendar month
+	Exceeded    bool  `json:"exceeded"`    // new streams are refused
+}
+
+// timing shows whether frames are emitted in real time. Lag is how far
+// emission is behind schedule, a lasting lag means the server can't keep up
+// (e.g. CPU starvation with too many clients).
+// Decoding speed shows the headroom, see -maxspeed.
+type timing struct {
+	MaxLag         string  `json:"maxLag"`         // in the last minute
+	AvgLag         string  `json:"avgLag"`         // in the last minute
+	FramesPerSec   float64 `json:"framesPerSec"`   // decoding throughput since start
+	RealtimeFactor float64 `json:"realtimeFactor"` // audio decoded per second of decoding since start
+}
+
+// lagMeter keeps lag of frame emission for the last minute in one second buckets.
+type lagMeter struct {
+	sync.Mutex
+
+	buckets [60]lagBucket
+}
+
+type lagBucket struct {
+	sec int64 // unix time of bucket
+	n   int
+	sum time.Duration
+	max time.Duration
+}
+
+func (lm *lagMeter) add(lag time.Duration) {
+	now := time.Now().Unix()
+	lm.Lock()
+	b := &lm.buckets[now%int64(len(lm.buckets))]
+	if b.sec != now {
+		*b = lagBucket{sec: now}
+	}
+	b.n++
+	b.sum += lag
+	if lag > b.max {
+		b.max = lag
+	}
+	lm.Unlock()
+}
+
+// stats returns maximum and average lag in the last minute.
+func (lm *lagMeter) stats() (max, avg time.Duration) {
+	now := time.Now().Unix()
+	var n int
+	var sum time.Duration
+	lm.Lock()
+	for _, b := range lm.buckets {
+		if now-b.sec >= int64(len(lm.buckets)) {
+			continue
+		}
+		n += b.n
+		sum += b.sum
+		if b.max > max {
+			max = b.max
+		}
+	}
+	lm.Unlock()
+	if n > 0 {
+		avg = sum / time.Duration(n)
+	}
+	return max, avg
+}
+
+// decodeMeter keeps decoding time of frames since start.
+type decodeMeter struct {
+	sync.Mutex
+
+	frames int64
+	audio  time.Duration // decoded
+	busy   time.Duration // spent decoding
+}
+
+func (dm *decodeMeter) add(audio, busy time.Duration) {
+	dm.Lock()
+	dm.frames++
+	dm.audio += audio
+	dm.busy += busy
+	dm.Unlock()
+}
+
+// stats returns frames decoded per second and the real-time factor of decoding.
+func (dm *decodeMeter) stats() (fps, rtf float64) {
+	dm.Lock()
+	defer dm.Unlock()
+	if dm.busy <= 0 {
+		return 0, 0
+	}
+	return float64(dm.frames) / dm.busy.Seconds(), dm.audio.Seconds() / dm.busy.Seconds()
+}
+
+// rateMeter keeps the average bitrate of all frames emitted.
+type rateMeter struct {
+	sync.Mutex
+
+	bytes int64
+	dur   time.Duration
+}
+
+func (rm *rateMeter) add(n int, d time.Duration) {
+	rm.Lock()
+	rm.bytes += int64(n)
+	rm.dur += d
+	rm.Unlock()
+}
+
+// kbps returns the average bitrate in kbit/s, 0 if nothing was emitted yet.
+func (rm *rateMeter) kbps() int {
+	rm.Lock()
+	defer rm.Unlock()
+	if rm.dur <= 0 {
+		return 0
+	}
+	return int(float64(rm.bytes*8) / rm.dur.Seconds() / 1000)
+}
+
+// kbps returns the bitrate of m's broadcast in kbit/s: the nominal one, or the average
+// of frames emitted so far, 0 if it's not known yet.
+func (m *mux) kbps() int {
+	if m.bitrate > 0 {
+		return m.bitrate
+	}
+	return m.rate.kbps()
+}
+
+// setPlaying records t as the track being broadcast.
+func (m *mux) setPlaying(t *track) {
+	np := nowPlaying{Path: t.path, Started: time.Now(), Source: t.source, TagBytes: t.tagSize}
+	if t.tag != nil {
+		np.Title, np.Artist, np.Album = t.tag.title, t.tag.artist, t.tag.album
+	}
+	m.Lock()
+	m.playing = np
+	if m.ahead == t.path {
+		m.ahead = ""
+	}
+	m.art = t.tag.cover() // only current track's picture is kept
+	close(m.trackChanged) // wake up event listeners
+	m.trackChanged = make(chan struct{})
+	m.Unlock()
+}
+
+// setSilence records that silence is broadcast instead of a track, why is logged.
+func (m *mux) setSilence(why string) {
+	m.Lock()
+	m.playing = nowPlaying{Started: time.Now(), Source: fromSilence}
+	m.art = nil
+	close(m.trackChanged)
+	m.trackChanged = make(chan struct{})
+	m.Unlock()
+	if *verbose {
+		fmt.Fprintf(infoOut, "Now playing: silence, %v\n", why)
+	}
+}
+
+// id3 returns an ID3v2 tag of np, the file name is the title if np has none, see -inbandid3.
+func (np nowPlaying) id3() []byte {
+	title := np.Title
+	if title == "" && np.Path != "" {
+		title = strings.TrimSuffix(filepath.Base(np.Path), filepath.Ext(np.Path))
+	}
+	return id3Frames(title, np.Artist, np.Album)
+}
+
+// resumePlaying records np (with cover art) as the track being broadcast again, after an interrupt.
+func (m *mux) resumePlaying(np nowPlaying, art *id3Picture) {
+	m.Lock()
+	m.playing = np
+	m.art = art
+	close(m.trackChanged)
+	m.trackChanged = make(chan struct{})
+	m.Unlock()
+}
+
+// crcError records a frame skipped due to CRC error.
+func (m *mux) crcError() {
+	m.Lock()
+	m.playing.CRCErrors++
+	m.playing.SkippedFrames++
+	m.skippedFrames++
+	m.Unlock()
+}
+
+// frameSkipped counts a frame of the current track skipped due to a decode error.
+func (m *mux) frameSkipped() {
+	m.Lock()
+	m.playing.SkippedFrames++
+	m.skippedFrames++
+	m.Unlock()
+}
+
+// gap records n bytes skipped before the frame at offset at of the current track.
+func (m *mux) gap(n int, at time.Duration) {
+	m.Lock()
+	m.playing.Gaps++
+	m.playing.GapBytes += n
+	m.gaps++
+	path := m.playing.Path
+	m.Unlock()
+	if *reportGaps {
+		at = at.Truncate(time.Second)
+		fmt.Fprintf(infoOut, "Gap at %d:%02d in %v, skipped %v bytes\n", int(at.Minutes()), int(at.Seconds())%60, path, n)
+	}
+}
+
+type statusHandler struct {
+	*mux
+
+	mounts map[string]*mux // by URL path
+}
+
+func (sh statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	maxLag, avgLag := sh.lag.stats()
+	fps, rtf := sh.decoding.stats()
+	sh.Lock()
+	st := status{
+		NowPlaying:    sh.playing,
+		State:         "playing",
+		Connections:   len(sh.clients),
+		SkippedFrames: sh.skippedFrames,
+		Gaps:          sh.gaps,
+		FrameSeq:      sh.frameSeq,
+		NextScheduled: sh.nextScheduled,
+		EmptyFiles:    sh.emptyFiles,
+		Timing:        timing{maxLag.String(), avgLag.String(), fps, rtf},
+	}
+	switch {
+	case sh.holding:
+		st.State = "holding"
+	case sh.stopAfter:
+		st.State = "stopping after current track"
+	}
+	sh.Unlock()
+	st.Playlist, _ = sh.playlists.current()
+	st.Requests = sh.requests.list()
+	if d := atomic.LoadInt64(&firstFrame); d > 0 {
+		st.FirstFrame = time.Duration(d).String()
+	}
+	for p, m := range sh.mounts {
+		state := "playing"
+		m.Lock()
+		stopped := m.stopped
+		m.Unlock()
+		switch {
+		case stopped:
+			state = "stopped"
+		case m.isEmpty():
+			state = "empty"
+		}
+		st.Mounts = append(st.Mounts, mount{p, state})
+	}
+	sort.Slice(st.Mounts, func(i, j int) bool { return st.Mounts[i].Path < st.Mounts[j].Path })
+	st.Egress.BytesPerSec, st.Egress.MonthBytes = egress.stats()
+	overRate, overMonth := egress.exceeded()
+	st.Egress.Exceeded = overRate || overMonth
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(st)
+}
+
+// artHandler serves the cover picture of the track being broadcast.
+type artHandler struct {
+	*mux
+}
+
+func (ah artHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ah.Lock()
+	art := ah.art
+	ah.Unlock()
+	if art == nil || !artTypes[art.mime] {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", art.mime)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(art.data)
+}
+
+// eventsHandler pushes now playing as server-sent events, an event is sent
+// on connect and whenever the track changes. Not counted against -max.
+type eventsHandler struct {
+	*mux
+}
+
+func (eh eventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	heartbeat := time.NewTicker(15 * time.Second) // keeps idle connections open through proxies
+	defer heartbeat.Stop()
+	for {
+		eh.Lock()
+		np, changed := eh.playing, eh.trackChanged
+		eh.Unlock()
+		b, err := json.Marshal(np)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: nowplaying\ndata: %s\n\n", b); err != nil {
+			return
+		}
+		flusher.Flush()
+
+	wait:
+		for {
+			select {
+			case <-changed:
+				break wait
+			case <-heartbeat.C:
+				if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			case <-eh.stopping:
+				return
+			}
+		}
+	}
+}
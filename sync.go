@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamClock maps wall clock time to stream time, the duration of audio broadcast since start.
+// Frames are emitted up to a second ahead of real time, the clock accounts for that.
+type streamClock struct {
+	sync.Mutex
+
+	emitted    time.Duration // audio emitted since start
+	ahead      time.Duration // audio emitted ahead of real time, at the last update
+	at         time.Time     // time of last update
+	trackStart time.Duration // stream time when current track started
+}
+
+// advance records a frame of duration d emitted, ahead of real time by ahead.
+func (c *streamClock) advance(d, ahead time.Duration) {
+	if ahead < 0 {
+		ahead = 0
+	}
+	c.Lock()
+	c.emitted += d
+	c.ahead = ahead
+	c.at = time.Now()
+	c.Unlock()
+}
+
+// newTrack marks the start of a track at the current stream time.
+func (c *streamClock) newTrack() {
+	c.Lock()
+	c.trackStart = c.emitted
+	c.Unlock()
+}
+
+// position returns the stream time and the time within the current track at now.
+func (c *streamClock) position(now time.Time) (stream, track time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	if c.at.IsZero() {
+		return 0, 0
+	}
+	stream = c.emitted - c.ahead + now.Sub(c.at)
+	if stream > c.emitted {
+		stream = c.emitted // nothing is emitted, stream is stalled
+	}
+	track = stream - c.trackStart
+	if track < 0 {
+		track = 0 // previous track is still playing
+	}
+	return stream, track
+}
+
+// syncInfo is served as JSON on /sync. A client aligns its playback by
+// playing stream time StreamTime at wall clock time ServerTime (e.g. by buffering
+// to a common target delay). The server only provides the mapping,
+// sample accurate synchronization of several clients needs their cooperation
+// (clock synchronization, e.g. NTP, and adjusting their buffers).
+type syncInfo struct {
+	ServerTime time.Time `json:"serverTime"`
+	StreamTime float64   `json:"streamTime"` // seconds of audio broadcast since start, playing at ServerTime
+	TrackTime  float64   `json:"trackTime"`  // seconds into current track
+	Emitted    float64   `json:"emitted"`    // seconds of audio sent to clients, StreamTime plus what's sent ahead
+	Path       string    `json:"path"`
+}
+
+type syncHandler struct {
+	*mux
+}
+
+func (sh syncHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	stream, track := sh.clock.position(now)
+	sh.clock.Lock()
+	emitted := sh.clock.emitted
+	sh.clock.Unlock()
+	sh.Lock()
+	path := sh.playing.Path
+	sh.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(syncInfo{
+		ServerTime: now,
+		StreamTime: stream.Seconds(),
+		TrackTime:  track.Seconds(),
+		Emitted:    emitted.Seconds(),
+		Path:       path,
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+// configureTLS sets up srv to serve HTTPS with the certificate and key in -tlscert and
+// -tlskey, if set. HTTP/2 is negotiated over TLS (ALPN) only with -http2 on, otherwise
+// every connection is HTTP/1.1. Cleartext HTTP/2 (h2c) isn't supported.
+func configureTLS(srv *http.Server) error {
+	if *tlsCert == "" && *tlsKey == "" {
+		if *http2 == "on" {
+			return errors.New("-http2 on needs -tlscert and -tlskey, cleartext HTTP/2 (h2c) isn't supported")
+		}
+		return nil
+	}
+	if *tlsCert == "" || *tlsKey == "" {
+		return errors.New("both -tlscert and -tlskey are needed")
+	}
+	cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	if *http2 != "on" {
+		// a non-nil TLSNextProto disables the automatic HTTP/2 support of http.Server
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	return nil
+}
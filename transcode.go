@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// transcodings are the formats ffmpeg can transcode the broadcast to, each is served at /codec.
+var transcodings = map[string]struct {
+	contentType string
+	args        []string // ffmpeg output options
+}{
+	"opus": {"audio/ogg", []string{"-c:a", "libopus", "-f", "ogg", "-page_duration", "100000"}},
+	"aac":  {"audio/aac", []string{"-c:a", "aac", "-f", "adts"}},
+	"mp3":  {"audio/mpeg", []string{"-c:a", "libmp3lame", "-f", "mp3"}},
+	// raw samples, served after a wav header, see pcmFormat
+	"pcm": {"audio/wav", []string{"-c:a", "pcm_s16le", "-ar", "44100", "-ac", "2", "-f", "s16le"}},
+}
+
+// pcmFormat is the format of the pcm transcoding. Decoded audio is resampled to it,
+// so wav clients, which can't follow format changes, keep playing if the broadcast's
+// format changes, still -lockformat is recommended. Decoding costs about as much CPU
+// as an aac transcoding, each client receives 1411kbps.
+var pcmFormat = wavFormat{channels: 2, sampleRate: 44100}
+
+// pcmChunk is the size of pcm chunks broadcast, 20ms of audio. Clients joining
+// mid-stream start at a chunk, i.e. at a sample boundary.
+var pcmChunk = pcmFormat.sampleRate / 50 * pcmFormat.blockAlign()
+
+// transcoder is a -transcode entry.
+type transcoder struct {
+	codec string
+	kbps  int
+}
+
+// parseTranscode parses -transcode, e.g. "opus:64,aac:96". The bitrate of pcm is fixed, e.g. "pcm".
+func parseTranscode(s string) ([]transcoder, error) {
+	var ts []transcoder
+	if s == "" {
+		return ts, nil
+	}
+	for _, e := range strings.Split(s, ",") {
+		codec, kbps := e, "64"
+		if i := strings.Index(e, ":"); i >= 0 {
+			codec, kbps = e[:i], e[i+1:]
+		}
+		if _, ok := transcodings[codec]; !ok {
+			return nil, fmt.Errorf("unsupported codec %#v, use opus, aac, mp3 or pcm", codec)
+		}
+		if codec == "pcm" {
+			kbps = strconv.Itoa(pcmFormat.sampleRate * pcmFormat.blockAlign() * 8 / 1000)
+		}
+		n, err := strconv.Atoi(kbps)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid bitrate %#v for %v", kbps, codec)
+		}
+		ts = append(ts, transcoder{codec, n})
+	}
+	return ts, nil
+}
+
+// transcode pipes the broadcast of src through ffmpeg (see -ffmpeg) and returns a mux
+// broadcasting ffmpeg's output in codec format. ffmpeg is restarted if it exits.
+// The transcoder is a client of src, it counts against -max.
+func transcode(src *mux, codec string, kbps int) *mux {
+	m := new(mux)
+	m.init(codec)
+	m.bitrate = kbps
+	m.upstream = src
+	m.transcoded = make(chan struct{})
+	if codec == "pcm" {
+		m.streamHeader = pcmFormat.header()
+	}
+	frames := make(chan streamFrame)
+	go m.broadcast(frames)
+
+	in := make(chan streamFrame)
+	c := &client{ch: in, remoteAddr: "ffmpeg", userAgent: "transcoder " + codec, started: time.Now(), internal: true}
+	m.upstreamQID = -1
+	qid, br := src.subscribe(c)
+	if qid < 0 {
+		log.Printf("Error: transcoding to %v unavailable, no connections left.", codec)
+		m.stop()
+		close(m.transcoded)
+		return m
+	}
+	m.upstreamQID = qid
+
+	var mu sync.Mutex
+	var stdin io.WriteCloser // of running ffmpeg, nil if none
+	done := false
+
+	// feed frames of src to ffmpeg, frames are dropped while ffmpeg is not running
+	go func() {
+		var fed io.WriteCloser // ffmpeg stdin src header was sent to
+		for buf := range in {
+			mu.Lock()
+			w := stdin
+			mu.Unlock()
+			if w != nil {
+				if w != fed {
+					buf = append(src.header(), buf...)
+					fed = w
+				}
+				if _, err := w.Write(buf); err != nil {
+					w.Close() // ffmpeg exits
+					mu.Lock()
+					if stdin == w {
+						stdin = nil
+					}
+					mu.Unlock()
+				}
+			}
+			atomic.AddInt64(&c.bytesSent, int64(len(buf)))
+			br <- broadcastResult{qid, nil}
+		}
+		// src stopped
+		mu.Lock()
+		done = true
+		if stdin != nil {
+			stdin.Close()
+		}
+		mu.Unlock()
+	}()
+
+	// run ffmpeg, broadcast its output
+	go func() {
+		defer close(m.transcoded)
+		defer m.stop()
+		args := []string{"-hide_banner", "-loglevel", "error", "-f", src.codec, "-i", "pipe:0", "-vn", "-b:a", strconv.Itoa(kbps) + "k"}
+		args = append(args, transcodings[codec].args...)
+		args = append(args, "pipe:1")
+		for {
+			mu.Lock()
+			if done || m.isStopping() {
+				mu.Unlock()
+				return
+			}
+			mu.Unlock()
+
+			cmd := exec.Command(*ffmpegPath, args...)
+			cmd.Stderr = os.Stderr
+			w, err := cmd.StdinPipe()
+			if err != nil {
+				log.Printf("Error: transcoding to %v failed: %v", codec, err)
+				return
+			}
+			r, err := cmd.StdoutPipe()
+			if err != nil {
+				log.Printf("Error: transcoding to %v failed: %v", codec, err)
+				return
+			}
+			if err := cmd.Start(); err != nil {
+				log.Printf("Error: transcoding to %v failed, is ffmpeg in PATH? %v", codec, err)
+				time.Sleep(10 * time.Second)
+				continue
+			}
+			if *verbose {
+				fmt.Fprintf(infoOut, "Transcoding to %v at %vkbps\n", codec, kbps)
+			}
+			mu.Lock()
+			stdin = w
+			mu.Unlock()
+
+			exited := make(chan struct{})
+			go func() {
+				select {
+				case <-m.stopping: // stopped directly, e.g. transcodePool.stop()
+					cmd.Process.Kill()
+				case <-exited:
+				}
+			}()
+			m.relay(bufio.NewReaderSize(r, 64*1024), frames)
+			cmd.Process.Kill() // output ended or is unusable
+
+			mu.Lock()
+			if stdin == w {
+				stdin = nil
+			}
+			mu.Unlock()
+			w.Close()
+			err = cmd.Wait()
+			close(exited)
+			mu.Lock()
+			stopped := done || m.isStopping()
+			mu.Unlock()
+			if stopped {
+				return
+			}
+			log.Printf("ffmpeg transcoding to %v exited (%v), restarting", codec, err)
+			time.Sleep(1 * time.Second)
+		}
+	}()
+
+	return m
+}
+
+// release stops transcoding to m: m unsubscribes from its upstream, ffmpeg exits,
+// then m stops and its clients are disconnected.
+func (m *mux) release() {
+	m.upstream.kick(m.upstreamQID)
+}
+
+// relay sends ffmpeg output read from r to frames until r ends or m is stopped.
+// Ogg output is sent page by page, header pages are kept for new clients.
+func (m *mux) relay(r *bufio.Reader, frames chan<- streamFrame) {
+	for {
+		var buf []byte
+		var err error
+		if m.codec == "opus" {
+			buf, err = readOggPage(r)
+			if err == nil && len(buf) > 0 {
+				m.Lock()
+				switch {
+				case buf[5]&0x02 != 0: // beginning of stream, e.g. ffmpeg restarted
+					m.streamHeader = buf
+				case bytes.Equal(buf[6:14], make([]byte, 8)): // granule position 0, more header
+					m.streamHeader = append(m.streamHeader[:len(m.streamHeader):len(m.streamHeader)], buf...)
+				}
+				m.Unlock()
+			}
+		} else if m.codec == "pcm" {
+			buf = make([]byte, pcmChunk)
+			var n int
+			n, err = io.ReadFull(r, buf)
+			buf = buf[:n-n%pcmFormat.blockAlign()]
+		} else {
+			buf = make([]byte, 4096)
+			var n int
+			n, err = r.Read(buf)
+			buf = buf[:n]
+		}
+		if len(buf) > 0 {
+			select {
+			case frames <- buf:
+			case <-m.stopping:
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF && debugging {
+				log.Printf("Reading ffmpeg output failed, err=%v", err)
+			}
+			return
+		}
+	}
+}
+
+// readOggPage reads a whole Ogg page from r.
+func readOggPage(r *bufio.Reader) ([]byte, error) {
+	h, err := r.Peek(27)
+	if err != nil {
+		return nil, err
+	}
+	if string(h[0:4]) != "OggS" {
+		return nil, errors.New("lost Ogg page sync")
+	}
+	nseg := int(h[26])
+	h, err = r.Peek(27 + nseg)
+	if err != nil {
+		return nil, err
+	}
+	n := 27 + nseg
+	for _, l := range h[27:] {
+		n += int(l)
+	}
+	page := make([]byte, n)
+	_, err = io.ReadFull(r, page)
+	return page, err
+}
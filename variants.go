@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transcodePool runs mp3 transcodings of a broadcast at bitrates requested by clients
+// with ?bitrate=, see -bitrates. Each bitrate has at most one transcoding (an ffmpeg
+// process and a connection to the broadcast), shared by its clients. A transcoding
+// is started by its first client and stopped 30 seconds after its last client left.
+// At most max transcodings run at a time, each takes a worker while it runs, see -decodeworkers.
+type transcodePool struct {
+	sync.Mutex
+
+	src     *mux
+	allowed map[int]bool // kbps
+	max     int
+	muxes   map[int]*mux // running transcodings by kbps
+	idle    map[int]time.Time
+	stopped bool // no more transcodings are started
+}
+
+// newTranscodePool returns a pool of transcodings of src at bitrates in allowed, e.g. "64,96".
+func newTranscodePool(src *mux, allowed string, max int) (*transcodePool, error) {
+	tp := &transcodePool{src: src, allowed: make(map[int]bool), max: max, muxes: make(map[int]*mux), idle: make(map[int]time.Time)}
+	for _, s := range strings.Split(allowed, ",") {
+		kbps, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || kbps <= 0 {
+			return nil, fmt.Errorf("invalid bitrate %#v", s)
+		}
+		tp.allowed[kbps] = true
+	}
+	go tp.reap()
+	return tp, nil
+}
+
+// allows reports whether bitrate (kbps) may be requested.
+func (tp *transcodePool) allows(bitrate string) bool {
+	kbps, err := strconv.Atoi(bitrate)
+	return err == nil && tp.allowed[kbps]
+}
+
+// get returns the transcoding at an allowed bitrate (kbps), started if not running.
+// Nil mux (and no error) means the broadcast itself should be streamed, its bitrate
+// isn't higher.
+func (tp *transcodePool) get(bitrate string) (*mux, error) {
+	kbps, _ := strconv.Atoi(bitrate)
+	if native := tp.src.rate.kbps(); tp.src.codec == "mp3" && native > 0 && kbps >= native {
+		return nil, nil
+	}
+
+	tp.Lock()
+	defer tp.Unlock()
+	if tp.stopped {
+		return nil, errors.New("shutting down")
+	}
+	if m, ok := tp.muxes[kbps]; ok {
+		m.Lock()
+		stopped := m.stopped
+		m.Unlock()
+		if !stopped {
+			return m, nil
+		}
+		delete(tp.muxes, kbps)
+		workers.release()
+	}
+	if len(tp.muxes) >= tp.max {
+		return nil, fmt.Errorf("too many bitrates requested, try one of %v", tp.running())
+	}
+	if !workers.tryAcquire() {
+		return nil, fmt.Errorf("all decode workers are busy, see -decodeworkers, try one of %v", tp.running())
+	}
+	m := transcode(tp.src, "mp3", kbps)
+	tp.muxes[kbps] = m
+	tp.idle[kbps] = time.Now() // grace period for the first client to subscribe
+	return m, nil
+}
+
+// stop stops all transcodings, their ffmpeg processes are killed, and no new ones are started.
+// Nil tp has none.
+func (tp *transcodePool) stop() {
+	if tp == nil {
+		return
+	}
+	tp.Lock()
+	defer tp.Unlock()
+	for kbps, m := range tp.muxes {
+		m.release()
+		m.stop()
+		select {
+		case <-m.transcoded:
+		case <-time.After(5 * time.Second):
+		}
+		delete(tp.muxes, kbps)
+		delete(tp.idle, kbps)
+		workers.release()
+	}
+	tp.stopped = true
+}
+
+// running returns the bitrates being transcoded. Called with tp locked.
+func (tp *transcodePool) running() []int {
+	var kbps []int
+	for k := range tp.muxes {
+		kbps = append(kbps, k)
+	}
+	return kbps
+}
+
+// reap stops transcodings without clients for 30 seconds.
+func (tp *transcodePool) reap() {
+	for {
+		time.Sleep(10 * time.Second)
+		tp.Lock()
+		for kbps, m := range tp.muxes {
+			m.Lock()
+			n := len(m.clients)
+			m.Unlock()
+			switch {
+			case n > 0:
+				delete(tp.idle, kbps)
+			case tp.idle[kbps].IsZero():
+				tp.idle[kbps] = time.Now()
+			case time.Since(tp.idle[kbps]) > 30*time.Second:
+				m.release()
+				delete(tp.muxes, kbps)
+				delete(tp.idle, kbps)
+				workers.release()
+				if *verbose {
+					fmt.Fprintf(infoOut, "Stopped transcoding to %vkbps, no clients\n", kbps)
+				}
+			}
+		}
+		tp.Unlock()
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// wavFormat is the format of uncompressed PCM audio in a .wav file.
+// Only 16 bit PCM is supported.
+type wavFormat struct {
+	channels   int
+	sampleRate int
+}
+
+const wavBitsPerSample = 16
+
+func (f wavFormat) blockAlign() int {
+	return f.channels * wavBitsPerSample / 8
+}
+
+func (f wavFormat) String() string {
+	return fmt.Sprintf("%v Hz, %v channels, %v bit PCM", f.sampleRate, f.channels, wavBitsPerSample)
+}
+
+// header returns a RIFF header for an endless stream of f formatted audio.
+// Sizes are set to the maximum, players keep playing until the connection ends.
+func (f wavFormat) header() []byte {
+	var b bytes.Buffer
+	le := binary.LittleEndian
+	b.WriteString("RIFF")
+	binary.Write(&b, le, uint32(0xFFFFFFFF))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, le, uint32(16))
+	binary.Write(&b, le, uint16(1)) // PCM
+	binary.Write(&b, le, uint16(f.channels))
+	binary.Write(&b, le, uint32(f.sampleRate))
+	binary.Write(&b, le, uint32(f.sampleRate*f.blockAlign()))
+	binary.Write(&b, le, uint16(f.blockAlign()))
+	binary.Write(&b, le, uint16(wavBitsPerSample))
+	b.WriteString("data")
+	binary.Write(&b, le, uint32(0xFFFFFFFF-36))
+	return b.Bytes()
+}
+
+// readWAVHeader reads the RIFF header from r up to the start of audio samples.
+// Returns the format and the length of audio data in bytes, or -1 if unknown.
+func readWAVHeader(r io.Reader) (wavFormat, int64, error) {
+	var f wavFormat
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return f, 0, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return f, 0, errors.New("not a RIFF WAVE file")
+	}
+
+	fmtFound := false
+	for {
+		var ch [8]byte
+		if _, err := io.ReadFull(r, ch[:]); err != nil {
+			return f, 0, err
+		}
+		size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+		switch string(ch[0:4]) {
+		case "fmt ":
+			if size < 16 {
+				return f, 0, errors.New("short fmt chunk")
+			}
+			var b [16]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return f, 0, err
+			}
+			if tag := binary.LittleEndian.Uint16(b[0:2]); tag != 1 {
+				return f, 0, fmt.Errorf("unsupported audio format %#x, only PCM is supported", tag)
+			}
+			if bits := binary.LittleEndian.Uint16(b[14:16]); bits != wavBitsPerSample {
+				return f, 0, fmt.Errorf("unsupported %v bits per sample, only %v bit is supported", bits, wavBitsPerSample)
+			}
+			f.channels = int(binary.LittleEndian.Uint16(b[2:4]))
+			f.sampleRate = int(binary.LittleEndian.Uint32(b[4:8]))
+			if f.channels < 1 || f.sampleRate < 1 {
+				return f, 0, errors.New("invalid fmt chunk")
+			}
+			fmtFound = true
+			size -= 16
+		case "data":
+			if !fmtFound {
+				return f, 0, errors.New("data chunk before fmt chunk")
+			}
+			if size == 0 || size == 0xFFFFFFFF {
+				size = -1 // streamed, length unknown
+			}
+			return f, size, nil
+		}
+		// skip rest of chunk, chunks are padded to even size
+		if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+			return f, 0, err
+		}
+	}
+}
+
+// decodeWAV sends 0.1 second chunks of PCM audio read from r to frames until r is exhausted
+// or limit long audio was sent, 0: no limit.
+// The format of the first file becomes the format of the stream, files with a different format are skipped.
+// Returns the number of chunks sent.
+func (m *mux) decodeWAV(r io.Reader, frames chan<- streamFrame, p *pacer, limit time.Duration) int {
+	f, size, err := readWAVHeader(r)
+	if err != nil {
+		if debugging {
+			log.Printf("Skipping wav stream, err=%v", err)
+		}
+		return 0
+	}
+
+	m.Lock()
+	if m.wavFmt == (wavFormat{}) {
+		m.wavFmt = f
+	}
+	streamFmt := m.wavFmt
+	m.Unlock()
+	if f != streamFmt {
+		if debugging {
+			log.Printf("Skipping wav stream, format %v differs from stream format %v", f, streamFmt)
+		}
+		return 0
+	}
+
+	if size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	n := 0
+	var played time.Duration
+	chunk := f.sampleRate / 10 * f.blockAlign()
+	for {
+		if m.skipped() {
+			return n
+		}
+		m.playInterrupt(frames, p)
+		p.start()
+		buf := make([]byte, chunk)
+		l, err := io.ReadFull(r, buf)
+		l -= l % f.blockAlign() // drop partial sample at end of file
+		if l > 0 {
+			d := time.Duration(l/f.blockAlign()) * time.Second / time.Duration(f.sampleRate)
+			p.decoded(d)
+			select {
+			case frames <- buf[:l]:
+			case <-m.stopping:
+				return n
+			}
+			n++
+			p.done(l, d)
+			played += d
+		}
+		if err != nil || (limit > 0 && played >= limit) {
+			return n
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+// workerPool limits how many background decodings (/library durations, -loudnessscan)
+// and ?bitrate= transcodings run at a time, see -decodeworkers. The live broadcast,
+// -transcode mounts, -mode independent streams and /play/ never take a worker, so they
+// never wait for one: background work waits for a free worker, a new ?bitrate= transcoding
+// is refused while all workers are busy.
+type workerPool chan struct{}
+
+// workers is nil if -decodeworkers is 0, unlimited.
+var workers workerPool
+
+// acquire blocks until a worker is free.
+func (wp workerPool) acquire() {
+	if wp != nil {
+		wp <- struct{}{}
+	}
+}
+
+// tryAcquire takes a worker if one is free.
+func (wp workerPool) tryAcquire() bool {
+	if wp == nil {
+		return true
+	}
+	select {
+	case wp <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees a worker taken by acquire or tryAcquire.
+func (wp workerPool) release() {
+	if wp != nil {
+		<-wp
+	}
+}